@@ -0,0 +1,151 @@
+package challenge
+
+import (
+	"context"
+	"errors"
+
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/ctfer-io/chall-manager/api/v1/common"
+	"github.com/ctfer-io/chall-manager/global"
+	errs "github.com/ctfer-io/chall-manager/pkg/errors"
+	"github.com/ctfer-io/chall-manager/pkg/fs"
+	"github.com/ctfer-io/chall-manager/pkg/labels"
+)
+
+func (store *Store) BulkDeleteChallenges(ctx context.Context, req *BulkDeleteChallengesRequest) (*BulkChallengesResponse, error) {
+	logger := global.Log()
+
+	// 1. Select the challenges
+	ids, err := selectChallenges(ctx, req.LabelSelector)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info(ctx, "bulk deleting challenges",
+		zap.String("selector", req.LabelSelector),
+		zap.Strings("challenges", ids),
+	)
+
+	// 2. Delete them one after the other, each going through its own locks
+	res := &BulkChallengesResponse{
+		Results: make([]*BulkChallengeResult, 0, len(ids)),
+	}
+	for _, id := range ids {
+		if ctx.Err() != nil {
+			break
+		}
+		_, err := store.DeleteChallenge(ctx, &DeleteChallengeRequest{Id: id})
+		res.Results = append(res.Results, bulkResult(id, nil, err))
+	}
+	return res, nil
+}
+
+func (store *Store) BulkUpdateChallenges(ctx context.Context, req *BulkUpdateChallengesRequest) (*BulkChallengesResponse, error) {
+	logger := global.Log()
+
+	// 0. Validate request
+	if req.Update == nil {
+		return nil, errors.New("update is required")
+	}
+
+	// 1. Select the challenges
+	ids, err := selectChallenges(ctx, req.LabelSelector)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info(ctx, "bulk updating challenges",
+		zap.String("selector", req.LabelSelector),
+		zap.Strings("challenges", ids),
+	)
+
+	// 2. Update them one after the other, each going through its own locks
+	res := &BulkChallengesResponse{
+		Results: make([]*BulkChallengeResult, 0, len(ids)),
+	}
+	for _, id := range ids {
+		if ctx.Err() != nil {
+			break
+		}
+		ureq := proto.Clone(req.Update).(*UpdateChallengeRequest)
+		ureq.Id = id
+		chall, err := store.UpdateChallenge(ctx, ureq)
+		res.Results = append(res.Results, bulkResult(id, chall, err))
+	}
+	return res, nil
+}
+
+// selectChallenges returns the sorted identifiers of the challenges which
+// labels match the selector.
+// The selector can't be empty, as bulk operations on all challenges are most
+// probably a mistake.
+func selectChallenges(ctx context.Context, selector string) ([]string, error) {
+	logger := global.Log()
+	span := trace.SpanFromContext(ctx)
+
+	sel, err := labels.Parse(selector)
+	if err != nil {
+		return nil, err
+	}
+	if sel.Empty() {
+		return nil, &errs.ErrInvalidSelector{
+			Selector: selector,
+			Reason:   "bulk operations require a non-empty selector",
+		}
+	}
+
+	// Lock RW TOTW, such that the challenges can be read
+	span.AddEvent("lock TOTW")
+	totw, err := common.LockTOTW(ctx)
+	if err != nil {
+		if totw.IsCanceled(err) {
+			return nil, nil
+		}
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "build TOTW lock", zap.Error(err))
+		return nil, errs.ErrInternalNoSub
+	}
+	if err := totw.RWLock(ctx); err != nil {
+		if totw.IsCanceled(err) {
+			return nil, nil
+		}
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "TOTW RW lock", zap.Error(err))
+		return nil, errs.ErrInternalNoSub
+	}
+	span.AddEvent("locked TOTW")
+
+	ids, err := fs.FilterChallenges(&fs.InstanceFilter{Labels: sel})
+	if err != nil {
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "listing challenges", zap.Error(multierr.Combine(
+			err,
+			totw.RWUnlock(context.WithoutCancel(ctx)),
+		)))
+		return nil, errs.ErrInternalNoSub
+	}
+
+	// Unlock RW TOTW
+	if err := totw.RWUnlock(context.WithoutCancel(ctx)); err != nil {
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "TOTW RW unlock", zap.Error(err))
+		return nil, errs.ErrInternalNoSub
+	}
+	span.AddEvent("unlocked TOTW")
+
+	return ids, nil
+}
+
+func bulkResult(id string, chall *Challenge, err error) *BulkChallengeResult {
+	res := &BulkChallengeResult{
+		Id:        id,
+		Challenge: chall,
+	}
+	if err != nil {
+		reason := err.Error()
+		res.Error = &reason
+	}
+	return res
+}
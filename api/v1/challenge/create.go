@@ -17,6 +17,7 @@ import (
 	errs "github.com/ctfer-io/chall-manager/pkg/errors"
 	"github.com/ctfer-io/chall-manager/pkg/fs"
 	"github.com/ctfer-io/chall-manager/pkg/iac"
+	"github.com/ctfer-io/chall-manager/pkg/labels"
 	"github.com/ctfer-io/chall-manager/pkg/lock"
 )
 
@@ -30,6 +31,18 @@ func (store *Store) CreateChallenge(ctx context.Context, req *CreateChallengeReq
 	if req.Min < 0 || req.Max < 0 || (req.Min > req.Max && req.Max != 0) {
 		return nil, fmt.Errorf("min/max out of bounds: %d/%d", req.Min, req.Max)
 	}
+	if req.MaxInstancesPerSource != nil && *req.MaxInstancesPerSource < 0 {
+		return nil, fmt.Errorf("max instances per source out of bounds: %d", *req.MaxInstancesPerSource)
+	}
+	if req.MaxRenews < 0 || (req.MaxLifetime != nil && req.MaxLifetime.AsDuration() <= 0) {
+		return nil, fmt.Errorf("renewal policy out of bounds: %d renews, %s lifetime", req.MaxRenews, req.MaxLifetime.AsDuration())
+	}
+	if err := labels.Validate(req.Labels); err != nil {
+		return nil, err
+	}
+	if req.Since != nil && req.Until != nil && !req.Since.AsTime().Before(req.Until.AsTime()) {
+		return nil, fmt.Errorf("since must be before until: %s/%s", req.Since.AsTime(), req.Until.AsTime())
+	}
 
 	// 1. Lock R TOTW
 	span.AddEvent("lock TOTW")
@@ -121,6 +134,15 @@ func (store *Store) CreateChallenge(ctx context.Context, req *CreateChallengeReq
 		Additional: req.Additional,
 		Min:        req.Min,
 		Max:        req.Max,
+
+		MaxInstancesPerSource: req.MaxInstancesPerSource,
+		MaxRenews:             req.MaxRenews,
+		MaxLifetime:           toDuration(req.MaxLifetime),
+		Shared:                req.Shared,
+		Labels:                req.Labels,
+		Since:                 toTime(req.Since),
+		Maintenance:           req.Maintenance,
+		Revision:              1,
 	}
 
 	if err := iac.Validate(ctx, fschall); err != nil {
@@ -148,6 +170,12 @@ func (store *Store) CreateChallenge(ctx context.Context, req *CreateChallengeReq
 		)
 		return nil, errs.ErrInternalNoSub
 	}
+	if err := appendRevision(ctx, fschall, ""); err != nil {
+		logger.Error(ctx, "recording challenge revision",
+			zap.Error(err),
+		)
+		return nil, errs.ErrInternalNoSub
+	}
 
 	logger.Info(ctx, "challenge created successfully")
 	common.ChallengesUDCounter().Add(ctx, 1)
@@ -161,6 +189,14 @@ func (store *Store) CreateChallenge(ctx context.Context, req *CreateChallengeReq
 		Additional: req.Additional,
 		Min:        req.Min,
 		Max:        req.Max,
+
+		MaxInstancesPerSource: req.MaxInstancesPerSource,
+		MaxRenews:             req.MaxRenews,
+		MaxLifetime:           req.MaxLifetime,
+		Shared:                req.Shared,
+		Labels:                req.Labels,
+		Since:                 req.Since,
+		Maintenance:           req.Maintenance,
 	}
 
 	// 8. Unlock RW challenge
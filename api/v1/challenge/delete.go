@@ -44,6 +44,22 @@ func (store *Store) DeleteChallenge(ctx context.Context, req *DeleteChallengeReq
 	}
 	span.AddEvent("locked TOTW")
 
+	// Cancel the in-flight operations on the challenge instances, elseway the
+	// challenge RW lock would wait for their completion. They clean up after themselves.
+	n, err := iac.CancelChallenge(ctx, req.Id)
+	if err != nil {
+		// Canceled, we need to recover
+		if err := totw.RUnlock(context.WithoutCancel(ctx)); err != nil {
+			err := &errs.ErrInternal{Sub: err}
+			logger.Error(ctx, "recovering from canceling instance operations", zap.Error(err))
+			return nil, errs.ErrInternalNoSub
+		}
+		return nil, nil
+	}
+	if n != 0 {
+		logger.Info(ctx, "canceled in-flight instance operations", zap.Int("operations", n))
+	}
+
 	// 2. Lock RW challenge
 	clock, err := common.LockChallenge(ctx, req.Id)
 	if err != nil {
@@ -132,7 +148,7 @@ func (store *Store) DeleteChallenge(ctx context.Context, req *DeleteChallengeReq
 				return
 			}
 
-			stack, err := iac.LoadStack(ctx, fschall.Scenario, identity)
+			stack, err := iac.LoadStack(ctx, fsist.RunningScenario(fschall.Scenario), identity)
 			if err != nil {
 				cerr <- err
 				return
@@ -0,0 +1,29 @@
+package challenge
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"github.com/ctfer-io/chall-manager/api/v1/common"
+)
+
+func (store *Store) ListChallenges(ctx context.Context, req *QueryChallengeRequest) (*ListChallengesResponse, error) {
+	// Run the query, collecting what it streams
+	col := common.NewCollector[Challenge](ctx)
+	if err := store.QueryChallenge(req, col); err != nil {
+		return nil, err
+	}
+
+	// Challenges are streamed as soon as read, thus in no particular order
+	challs := col.Items()
+	slices.SortFunc(challs, func(a, b *Challenge) int {
+		return strings.Compare(a.Id, b.Id)
+	})
+
+	res := &ListChallengesResponse{
+		Challenges: challs,
+	}
+	res.NextPageToken, _ = col.Header("next-page-token")
+	return res, nil
+}
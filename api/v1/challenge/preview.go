@@ -0,0 +1,115 @@
+package challenge
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+
+	"github.com/ctfer-io/chall-manager/api/v1/common"
+	"github.com/ctfer-io/chall-manager/api/v1/instance"
+	"github.com/ctfer-io/chall-manager/global"
+	errs "github.com/ctfer-io/chall-manager/pkg/errors"
+	"github.com/ctfer-io/chall-manager/pkg/fs"
+	"github.com/ctfer-io/chall-manager/pkg/iac"
+	"github.com/ctfer-io/chall-manager/pkg/lock"
+)
+
+// previewUpdate previews the update of all the challenge instances, claimed
+// and pooled, to the (not saved) challenge, without changing anything.
+// Instances that fail to preview report the reason of this failure.
+func previewUpdate(ctx context.Context, fschall *fs.Challenge, strategy UpdateStrategy) (*Challenge, error) {
+	logger := global.Log()
+
+	ists, err := fs.ListInstances(fschall.ID)
+	if err != nil {
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "listing instances",
+			zap.Error(err),
+		)
+		return nil, errs.ErrInternalNoSub
+	}
+
+	logger.Info(ctx, "previewing challenge update",
+		zap.Int("instances", len(ists)),
+		zap.String("strategy", strategy.String()),
+	)
+
+	mx := &sync.Mutex{}
+	previews := make([]*instance.InstancePreview, 0, len(ists))
+	work := &sync.WaitGroup{}
+	work.Add(len(ists))
+	for _, identity := range ists {
+		go func(identity string) {
+			ctx, span := global.Tracer.Start(ctx, "previewing-instance", trace.WithAttributes(
+				attribute.String("identity", identity),
+			))
+			defer span.End()
+
+			defer work.Done()
+			ctx = global.WithIdentity(ctx, identity)
+			sourceID, _ := fs.LookupClaim(fschall.ID, identity)
+
+			diff, err := previewInstance(ctx, fschall, identity, strategy)
+			if err != nil {
+				logger.Error(ctx, "previewing instance update", zap.Error(err))
+			}
+
+			mx.Lock()
+			previews = append(previews, instance.FromDiff(fschall.ID, sourceID, identity, diff, err))
+			mx.Unlock()
+		}(identity)
+	}
+	work.Wait()
+
+	slices.SortFunc(previews, func(a, b *instance.InstancePreview) int {
+		return strings.Compare(a.GetIdentity(), b.GetIdentity())
+	})
+
+	return &Challenge{
+		Id:         fschall.ID,
+		Scenario:   fschall.Scenario,
+		Additional: fschall.Additional,
+		Min:        fschall.Min,
+		Max:        fschall.Max,
+		Timeout:    toPBDuration(fschall.Timeout),
+		Until:      toPBTimestamp(fschall.Until),
+		Instances:  []*instance.Instance{},
+
+		MaxInstancesPerSource: fschall.MaxInstancesPerSource,
+		MaxRenews:             fschall.MaxRenews,
+		MaxLifetime:           toPBDuration(fschall.MaxLifetime),
+		Shared:                fschall.Shared,
+		Labels:                fschall.Labels,
+		Since:                 toPBTimestamp(fschall.Since),
+		Maintenance:           fschall.Maintenance,
+		Previews:              previews,
+	}, nil
+}
+
+// previewInstance previews the update of an instance under its R lock.
+func previewInstance(ctx context.Context, fschall *fs.Challenge, identity string, strategy UpdateStrategy) (*iac.Diff, error) {
+	ilock, err := common.LockInstance(ctx, fschall.ID, identity)
+	if err != nil {
+		return nil, err
+	}
+	if err := ilock.RLock(ctx); err != nil {
+		return nil, err
+	}
+	defer func(lock lock.RWLock) {
+		if err := lock.RUnlock(context.WithoutCancel(ctx)); err != nil {
+			err := &errs.ErrInternal{Sub: err}
+			global.Log().Error(ctx, "instance R unlock", zap.Error(err))
+		}
+	}(ilock)
+
+	fsist, err := fs.LoadInstance(fschall.ID, identity)
+	if err != nil {
+		return nil, err
+	}
+	return iac.PreviewUpdate(ctx, strategy.String(), fschall, fsist)
+}
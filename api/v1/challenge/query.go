@@ -8,22 +8,38 @@ import (
 	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/multierr"
 	"go.uber.org/zap"
-	"google.golang.org/protobuf/types/known/emptypb"
-	"google.golang.org/protobuf/types/known/timestamppb"
 
 	"github.com/ctfer-io/chall-manager/api/v1/common"
 	"github.com/ctfer-io/chall-manager/api/v1/instance"
 	"github.com/ctfer-io/chall-manager/global"
 	errs "github.com/ctfer-io/chall-manager/pkg/errors"
 	"github.com/ctfer-io/chall-manager/pkg/fs"
+	"github.com/ctfer-io/chall-manager/pkg/labels"
 	"github.com/ctfer-io/chall-manager/pkg/lock"
 )
 
-func (store *Store) QueryChallenge(_ *emptypb.Empty, server ChallengeStore_QueryChallengeServer) error {
+func (store *Store) QueryChallenge(req *QueryChallengeRequest, server ChallengeStore_QueryChallengeServer) error {
 	logger := global.Log()
 	ctx := server.Context()
 	span := trace.SpanFromContext(ctx)
 
+	// 0. Validate request
+	if req.PageSize < 0 {
+		return common.ErrInvalidPageSize
+	}
+	sel, err := labels.Parse(req.LabelSelector)
+	if err != nil {
+		return err
+	}
+	claimed := true // only claimed instances are embedded
+	filter := &fs.InstanceFilter{
+		ChallengeIDs:   req.ChallengeIds,
+		SourceIDs:      req.SourceIds,
+		Claimed:        &claimed,
+		ExpiringBefore: instance.ExpiringBefore(req.ExpiringBefore, req.Expired),
+		Labels:         sel,
+	}
+
 	// 1. Lock RW TOTW
 	span.AddEvent("lock TOTW")
 	totw, err := common.LockTOTW(ctx)
@@ -45,8 +61,8 @@ func (store *Store) QueryChallenge(_ *emptypb.Empty, server ChallengeStore_Query
 	}
 	span.AddEvent("locked TOTW")
 
-	// 2. Fetch all challenges
-	ids, err := fs.ListChallenges()
+	// 2. Fetch challenges that match the filter, and paginate them
+	ids, err := fs.FilterChallenges(filter)
 	if err != nil {
 		err := &errs.ErrInternal{Sub: err}
 		logger.Error(ctx, "listing challenges",
@@ -57,6 +73,23 @@ func (store *Store) QueryChallenge(_ *emptypb.Empty, server ChallengeStore_Query
 		)
 		return errs.ErrInternalNoSub
 	}
+	ids, next, err := common.Paginate(ids, func(id string) string { return id }, req.PageSize, req.PageToken)
+	if err != nil {
+		return multierr.Combine(
+			totw.RWUnlock(context.WithoutCancel(ctx)),
+			err,
+		)
+	}
+	if err := common.SetNextPageToken(server, next); err != nil {
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "setting next page token header",
+			zap.Error(multierr.Append(
+				totw.RWUnlock(context.WithoutCancel(ctx)),
+				err,
+			)),
+		)
+		return errs.ErrInternalNoSub
+	}
 
 	// 3. Create "relock" and "work" wait groups for all challenges, and for each
 	qs := common.NewQueryServer[*Challenge](server)
@@ -116,51 +149,20 @@ func (store *Store) QueryChallenge(_ *emptypb.Empty, server ChallengeStore_Query
 				return
 			}
 
-			// 4.d. Fetch challenge instances
+			// 4.d. Fetch challenge instances that match the filter, if required
 			//      (don't lock and access concurrently, most probably fast enough even at scale)
 			//      (if required to perform concurrently, no breaking change so LGTM)
-			clmIsts := map[string]string{}
-			ists, err := fs.ListInstances(id)
-			if err != nil {
-				cerr <- err
-				return
-			}
-			for _, ist := range ists {
-				src, err := fs.LookupClaim(id, ist)
-				if err != nil {
-					// in pool
-					continue
-				}
-				clmIsts[src] = ist
-			}
-			oists := make([]*instance.Instance, 0, len(clmIsts))
-			for sourceID, identity := range clmIsts {
-				fsist, err := fs.LoadInstance(id, identity)
+			var oists []*instance.Instance
+			if !req.OmitInstances {
+				ists, err := fs.FilterInstances(id, filter)
 				if err != nil {
 					cerr <- err
 					return
 				}
-
-				var until *timestamppb.Timestamp
-				if fsist.Until != nil {
-					until = timestamppb.New(*fsist.Until)
+				oists = make([]*instance.Instance, 0, len(ists))
+				for _, ist := range ists {
+					oists = append(oists, instance.FromFS(ist.Instance, ist.SourceID))
 				}
-				oists = append(oists, &instance.Instance{
-					ChallengeId:    id,
-					SourceId:       sourceID,
-					Since:          timestamppb.New(fsist.Since),
-					LastRenew:      timestamppb.New(fsist.LastRenew),
-					Until:          until,
-					ConnectionInfo: fsist.ConnectionInfo,
-					Flag: func() *string { // kept for retrocompatibility enough time for public migration
-						if len(fsist.Flags) == 1 {
-							return &fsist.Flags[0]
-						}
-						return nil
-					}(),
-					Flags:      fsist.Flags,
-					Additional: fsist.Additional,
-				})
 			}
 
 			if err := qs.SendMsg(&Challenge{
@@ -172,6 +174,14 @@ func (store *Store) QueryChallenge(_ *emptypb.Empty, server ChallengeStore_Query
 				Additional: fschall.Additional,
 				Min:        fschall.Min,
 				Max:        fschall.Max,
+
+				MaxInstancesPerSource: fschall.MaxInstancesPerSource,
+				MaxRenews:             fschall.MaxRenews,
+				MaxLifetime:           toPBDuration(fschall.MaxLifetime),
+				Shared:                fschall.Shared,
+				Labels:                fschall.Labels,
+				Since:                 toPBTimestamp(fschall.Since),
+				Maintenance:           fschall.Maintenance,
 			}); err != nil {
 				cerr <- err
 				return
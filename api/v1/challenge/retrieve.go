@@ -142,26 +142,7 @@ func (store *Store) RetrieveChallenge(ctx context.Context, req *RetrieveChalleng
 			return nil, err
 		}
 
-		var until *timestamppb.Timestamp
-		if fsist.Until != nil {
-			until = timestamppb.New(*fsist.Until)
-		}
-		oists = append(oists, &instance.Instance{
-			ChallengeId:    req.Id,
-			SourceId:       sourceID,
-			Since:          timestamppb.New(fsist.Since),
-			LastRenew:      timestamppb.New(fsist.LastRenew),
-			Until:          until,
-			ConnectionInfo: fsist.ConnectionInfo,
-			Flag: func() *string { // kept for retrocompatibility enough time for public migration
-				if len(fsist.Flags) == 1 {
-					return &fsist.Flags[0]
-				}
-				return nil
-			}(),
-			Flags:      fsist.Flags,
-			Additional: fsist.Additional,
-		})
+		oists = append(oists, instance.FromFS(fsist, sourceID))
 	}
 
 	return &Challenge{
@@ -173,6 +154,14 @@ func (store *Store) RetrieveChallenge(ctx context.Context, req *RetrieveChalleng
 		Additional: fschall.Additional,
 		Min:        fschall.Min,
 		Max:        fschall.Max,
+
+		MaxInstancesPerSource: fschall.MaxInstancesPerSource,
+		MaxRenews:             fschall.MaxRenews,
+		MaxLifetime:           toPBDuration(fschall.MaxLifetime),
+		Shared:                fschall.Shared,
+		Labels:                fschall.Labels,
+		Since:                 toPBTimestamp(fschall.Since),
+		Maintenance:           fschall.Maintenance,
 	}, nil
 }
 
@@ -0,0 +1,200 @@
+package challenge
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/ctfer-io/chall-manager/api/v1/common"
+	"github.com/ctfer-io/chall-manager/global"
+	errs "github.com/ctfer-io/chall-manager/pkg/errors"
+	"github.com/ctfer-io/chall-manager/pkg/fs"
+	"github.com/ctfer-io/chall-manager/pkg/lock"
+)
+
+func (store *Store) ListChallengeRevisions(ctx context.Context, req *ListChallengeRevisionsRequest) (*ListChallengeRevisionsResponse, error) {
+	logger := global.Log()
+	ctx = global.WithChallengeID(ctx, req.Id)
+	span := trace.SpanFromContext(ctx)
+
+	// 1. Lock R TOTW
+	span.AddEvent("lock TOTW")
+	totw, err := common.LockTOTW(ctx)
+	if err != nil {
+		if totw.IsCanceled(err) {
+			return nil, nil
+		}
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "build TOTW lock", zap.Error(err))
+		return nil, errs.ErrInternalNoSub
+	}
+	if err := totw.RLock(ctx); err != nil {
+		if totw.IsCanceled(err) {
+			return nil, nil
+		}
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "TOTW R lock", zap.Error(err))
+		return nil, errs.ErrInternalNoSub
+	}
+	span.AddEvent("locked TOTW")
+
+	// 2. Lock R challenge
+	clock, err := common.LockChallenge(ctx, req.Id)
+	if err != nil {
+		if clock.IsCanceled(err) {
+			// If canceled, we need to recover
+			if err := totw.RUnlock(context.WithoutCancel(ctx)); err != nil {
+				err := &errs.ErrInternal{Sub: err}
+				logger.Error(ctx, "recovering from build challenge lock", zap.Error(err))
+				return nil, errs.ErrInternalNoSub
+			}
+			return nil, nil // recovery is successful, we can quit safely
+		}
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "build challenge lock", zap.Error(multierr.Combine(
+			totw.RUnlock(context.WithoutCancel(ctx)),
+			err,
+		)))
+		return nil, errs.ErrInternalNoSub
+	}
+	if err := clock.RLock(ctx); err != nil {
+		if clock.IsCanceled(err) {
+			// If canceled, we need to recover
+			if err := totw.RUnlock(context.WithoutCancel(ctx)); err != nil {
+				err := &errs.ErrInternal{Sub: err}
+				logger.Error(ctx, "recovering from challenge R lock", zap.Error(err))
+				return nil, errs.ErrInternalNoSub
+			}
+			return nil, nil // recovery is successful, we can quit safely
+		}
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "challenge R lock", zap.Error(multierr.Combine(
+			totw.RUnlock(context.WithoutCancel(ctx)),
+			err,
+		)))
+		return nil, errs.ErrInternalNoSub
+	}
+	defer func(lock lock.RWLock) {
+		if err := lock.RUnlock(context.WithoutCancel(ctx)); err != nil {
+			err := &errs.ErrInternal{Sub: err}
+			logger.Error(ctx, "challenge R unlock", zap.Error(err))
+		}
+	}(clock)
+
+	// 3. Unlock R TOTW
+	if err := totw.RUnlock(context.WithoutCancel(ctx)); err != nil {
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "TOTW R unlock", zap.Error(err))
+		return nil, errs.ErrInternalNoSub
+	}
+	span.AddEvent("unlocked TOTW")
+
+	// 4. If challenge does not exist, return error
+	if err := fs.CheckChallenge(req.Id); err != nil {
+		return nil, err
+	}
+
+	// 5. Read the challenge revisions
+	revs, err := fs.LoadRevisions(req.Id)
+	if err != nil {
+		logger.Error(ctx, "loading challenge revisions", zap.Error(err))
+		return nil, errs.ErrInternalNoSub
+	}
+	resp := &ListChallengeRevisionsResponse{
+		Revisions: make([]*ChallengeRevision, 0, len(revs)),
+	}
+	for _, rev := range revs {
+		resp.Revisions = append(resp.Revisions, fromRevision(rev))
+	}
+
+	// 6. Unlock R challenge
+	//    -> defered after 2 (fault-tolerance)
+
+	return resp, nil
+}
+
+func (store *Store) RollbackChallenge(ctx context.Context, req *RollbackChallengeRequest) (*Challenge, error) {
+	logger := global.Log()
+	ctx = global.WithChallengeID(ctx, req.Id)
+
+	// 1. Look for the revision. The history is append-only, hence can be read
+	//    before UpdateChallenge acquires the locks.
+	if err := fs.CheckChallenge(req.Id); err != nil {
+		return nil, err
+	}
+	rev, err := fs.LoadRevision(req.Id, req.Revision)
+	if err != nil {
+		if err, ok := err.(*errs.ErrInternal); ok {
+			logger.Error(ctx, "loading challenge revision", zap.Error(err))
+			return nil, errs.ErrInternalNoSub
+		}
+		return nil, err
+	}
+
+	// 2. Replay the revision through the update machinery
+	strategy := UpdateStrategy(UpdateStrategy_value[rev.UpdateStrategy])
+	if req.UpdateStrategy != nil {
+		strategy = *req.UpdateStrategy
+	}
+	logger.Info(ctx, "rolling back challenge",
+		zap.Int64("revision", rev.Revision),
+		zap.String("scenario", rev.Scenario),
+	)
+	return store.UpdateChallenge(ctx, &UpdateChallengeRequest{
+		Id:             req.Id,
+		Scenario:       &rev.Scenario,
+		UpdateStrategy: &strategy,
+		Timeout:        toPBDuration(rev.Timeout),
+		Until:          toPBTimestamp(rev.Until),
+		Additional:     rev.Additional,
+		Min:            rev.Min,
+		Max:            rev.Max,
+		UpdateMask: &fieldmaskpb.FieldMask{
+			Paths: []string{"scenario", "timeout", "until", "additional", "min", "max"},
+		},
+	})
+}
+
+// appendRevision records the challenge configuration as its latest revision, which
+// number is expected to be the challenge one.
+// The scenario is pinned to its digest whenever it can be resolved.
+// The caller must hold the challenge RW lock.
+func appendRevision(ctx context.Context, fschall *fs.Challenge, strategy string) error {
+	scn, err := global.GetOCIManager().Digest(ctx, fschall.Scenario)
+	if err != nil {
+		global.Log().Warn(ctx, "resolving scenario digest, recording reference as is",
+			zap.String("reference", fschall.Scenario),
+			zap.Error(err),
+		)
+		scn = fschall.Scenario
+	}
+	return fs.AppendRevision(fschall.ID, &fs.Revision{
+		Scenario:       scn,
+		Additional:     fschall.Additional,
+		Timeout:        fschall.Timeout,
+		Until:          fschall.Until,
+		Min:            fschall.Min,
+		Max:            fschall.Max,
+		UpdateStrategy: strategy,
+		At:             time.Now(),
+	})
+}
+
+func fromRevision(rev *fs.Revision) *ChallengeRevision {
+	return &ChallengeRevision{
+		Revision:       rev.Revision,
+		Scenario:       rev.Scenario,
+		Additional:     rev.Additional,
+		Timeout:        toPBDuration(rev.Timeout),
+		Until:          toPBTimestamp(rev.Until),
+		Min:            rev.Min,
+		Max:            rev.Max,
+		UpdateStrategy: UpdateStrategy(UpdateStrategy_value[rev.UpdateStrategy]),
+		At:             timestamppb.New(rev.At),
+	}
+}
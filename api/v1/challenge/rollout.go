@@ -0,0 +1,360 @@
+package challenge
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/ctfer-io/chall-manager/api/v1/common"
+	"github.com/ctfer-io/chall-manager/api/v1/instance"
+	"github.com/ctfer-io/chall-manager/global"
+	errs "github.com/ctfer-io/chall-manager/pkg/errors"
+	"github.com/ctfer-io/chall-manager/pkg/events"
+	"github.com/ctfer-io/chall-manager/pkg/fs"
+	"github.com/ctfer-io/chall-manager/pkg/iac"
+	"github.com/ctfer-io/chall-manager/pkg/lock"
+)
+
+// rollout applies the update of a challenge to its instances.
+// The caller must hold the challenge RW lock.
+type rollout struct {
+	fschall *fs.Challenge
+
+	// prevScn is the scenario the instances are updated from, and prevRev the
+	// revision it comes from.
+	prevScn string
+	prevRev int64
+
+	// update is set when the instances infrastructure has to be updated, i.e.
+	// when the scenario or the additional values changed.
+	update   bool
+	strategy UpdateStrategy
+
+	// sem bounds the number of instances worked on at once, if set.
+	sem chan struct{}
+
+	mx      sync.Mutex
+	claimed []string // identities of the claimed instances, whatever their update outcome
+}
+
+// concurrency returns the maximum number of instances to update at once given
+// the update strategy, or 0 if there is no limit.
+// Only the recreate strategy makes the instances unavailable while updating them.
+func concurrency(strategy UpdateStrategy, parallelism, maxUnavailable int64) int64 {
+	if strategy == UpdateStrategy_recreate && maxUnavailable != 0 && (parallelism == 0 || maxUnavailable < parallelism) {
+		return maxUnavailable
+	}
+	return parallelism
+}
+
+// bound limits the number of instances worked on at once, if n is not 0.
+func (ro *rollout) bound(n int64) {
+	if n != 0 {
+		ro.sem = make(chan struct{}, n)
+	}
+}
+
+// acquire waits for a slot to work on an instance, and returns its release.
+func (ro *rollout) acquire() func() {
+	if ro.sem == nil {
+		return func() {}
+	}
+	ro.sem <- struct{}{}
+	return func() { <-ro.sem }
+}
+
+// target is an instance to update, claimed if it has a source.
+type target struct {
+	sourceID string
+	identity string
+}
+
+// run updates the targets concurrently, and returns their errors in the same order.
+func (ro *rollout) run(ctx context.Context, targets []target) []error {
+	results := make([]error, len(targets))
+	work := &sync.WaitGroup{}
+	work.Add(len(targets))
+	for i, t := range targets {
+		go func(i int, t target) {
+			defer work.Done()
+			defer ro.acquire()()
+
+			if t.sourceID != "" {
+				results[i] = ro.updateClaimed(ctx, t.sourceID, t.identity)
+			} else {
+				results[i] = ro.updatePooled(ctx, t.identity)
+			}
+		}(i, t)
+	}
+	work.Wait()
+	return results
+}
+
+func (ro *rollout) updateClaimed(ctx context.Context, sourceID, identity string) error {
+	logger := global.Log()
+
+	// Track span of loading stack
+	ctx, span := global.Tracer.Start(ctx, "updating-instance", trace.WithAttributes(
+		attribute.String("source_id", sourceID),
+		attribute.String("identity", identity),
+	))
+	defer span.End()
+
+	ctx = global.WithSourceID(ctx, sourceID)
+	ctx = global.WithIdentity(ctx, identity)
+
+	// Report the instance among the claimed ones, even if it fails to load or update, by
+	// its identity once updated (e.g. can be another one with recreate)
+	claimedID := identity
+	defer func() {
+		ro.mx.Lock()
+		ro.claimed = append(ro.claimed, claimedID)
+		ro.mx.Unlock()
+	}()
+
+	// 1. Lock RW instance
+	ilock, err := common.LockInstance(ctx, ro.fschall.ID, identity)
+	if err != nil {
+		if ilock.IsCanceled(err) {
+			return nil
+		}
+		return err
+	}
+	if err := ilock.RWLock(ctx); err != nil {
+		if ilock.IsCanceled(err) {
+			return nil
+		}
+		return err
+	}
+	defer func(lock lock.RWLock) {
+		if err := lock.RWUnlock(context.WithoutCancel(ctx)); err != nil {
+			err := &errs.ErrInternal{Sub: err}
+			logger.Error(ctx, "instance RW unlock", zap.Error(err))
+		}
+	}(ilock)
+
+	fsist, err := fs.LoadInstance(ro.fschall.ID, identity)
+	if err != nil {
+		return err
+	}
+
+	// 2. Mirror instance's "until" based on the challenge
+	fsist.Until = common.ComputeUntil(ro.fschall.Until, ro.fschall.Timeout)
+
+	// Keep track of who is the owner of the instance
+	oldIst := *fsist
+	oldID := fsist.Identity
+
+	// 3. Then update if necessary, failed instances have nothing to update.
+	//    Deferred updates are left to the next renewal.
+	switch {
+	case ro.deferred():
+	case fsist.IsReady() && ro.update:
+		if err := iac.Update(ctx, fsist.RunningScenario(ro.prevScn), ro.strategy.String(), ro.fschall, fsist); err != nil {
+			return err
+		}
+	case !fsist.Outdated(ro.prevRev):
+		// Nothing to update, the instance follows the challenge
+		fsist.Revision = ro.fschall.Revision
+	}
+
+	// Save potentially updated instance
+	newIst := fsist.Identity
+	if err := fsist.Save(); err != nil {
+		return err
+	}
+	claimedID = newIst
+
+	// (Re-)claim the instance (e.g. can be another one with recreate)
+	if err := fsist.Claim(sourceID); err != nil {
+		if _, ok := err.(*fs.ErrAlreadyClaimed); !ok {
+			return err
+		}
+	}
+	if err := fsist.SaveMembers(); err != nil {
+		return err
+	}
+
+	events.Publish(ctx, events.New(events.Updated, sourceID, fsist))
+
+	if !slices.Equal(oldIst.Flags, fsist.Flags) {
+		// Keep track of the previous flags for leak detection, best effort
+		if err := oldIst.ArchiveFlags(sourceID); err != nil {
+			logger.Error(ctx, "archiving instance flags", zap.Error(err))
+		}
+	}
+
+	if oldID != newIst {
+		// Delete old instance (unused resources)
+		oldIst := &fs.Instance{
+			ChallengeID: ro.fschall.ID,
+			Identity:    oldID,
+		}
+		if err := oldIst.Delete(); err != nil {
+			return err
+		}
+	}
+
+	// 4. Unlock RW instance
+	//    -> defered after 1. (fault-tolerance)
+
+	return nil
+}
+
+func (ro *rollout) updatePooled(ctx context.Context, identity string) error {
+	ctx, span := global.Tracer.Start(ctx, "update-instance", trace.WithAttributes(
+		attribute.String("identity", identity),
+	))
+	defer span.End()
+
+	ctx = global.WithIdentity(ctx, identity)
+
+	fsist, err := fs.LoadInstance(ro.fschall.ID, identity)
+	if err != nil {
+		return err
+	}
+
+	// Update iif required to do so, elseway do nothing
+	if ro.update {
+		if err := iac.Update(ctx, fsist.RunningScenario(ro.prevScn), ro.strategy.String(), ro.fschall, fsist); err != nil {
+			return err
+		}
+	} else if !fsist.Outdated(ro.prevRev) {
+		fsist.Revision = ro.fschall.Revision
+	}
+
+	return fsist.Save()
+}
+
+// deferred returns whether the claimed instances infrastructure update is left
+// to their next renewal.
+func (ro *rollout) deferred() bool {
+	return ro.update && ro.strategy == UpdateStrategy_deferred
+}
+
+// revert rolls all the canaries back to the previous challenge, the ones that
+// failed included as they may have been partially updated, and reports the
+// outcome of all the targets: the canaries were reverted (along their update
+// error if any) or failed to, and the others were skipped.
+func (ro *rollout) revert(ctx context.Context, prev *fs.Challenge, canaries []target, cerrs []error, others []target) []*instance.InstanceUpdate {
+	logger := global.Log()
+
+	back := &rollout{
+		fschall:  prev,
+		prevScn:  ro.fschall.Scenario,
+		prevRev:  ro.fschall.Revision,
+		update:   true,
+		strategy: UpdateStrategy_update_in_place,
+		sem:      ro.sem,
+	}
+	berrs := back.run(ctx, canaries)
+
+	updates := make([]*instance.InstanceUpdate, 0, len(canaries)+len(others))
+	for i, t := range canaries {
+		if berrs[i] != nil {
+			logger.Error(ctx, "reverting canary",
+				zap.String("identity", t.identity),
+				zap.Error(berrs[i]),
+			)
+			updates = append(updates, ro.report(ctx, t, true, berrs[i]))
+			continue
+		}
+		upd := ro.report(ctx, t, true, cerrs[i])
+		upd.Outcome = instance.UpdateOutcome_update_reverted
+		updates = append(updates, upd)
+	}
+	for _, t := range others {
+		updates = append(updates, instance.FromUpdate(ro.fschall.ID, t.sourceID, t.identity, false, instance.UpdateOutcome_update_skipped, nil))
+	}
+	return updates
+}
+
+// canariesFailed returns the error of an update aborted as its canaries failed.
+// Its details carry the challenge as left, i.e. unchanged, along the outcome of
+// each instance.
+func canariesFailed(chall *Challenge) error {
+	st := status.New(codes.Aborted, fmt.Sprintf("canaries of challenge %s failed, the update has been reverted", chall.Id))
+	if dst, err := st.WithDetails(chall); err == nil {
+		st = dst
+	}
+	return st.Err()
+}
+
+// report returns the outcome of the update of the target given its error.
+// Internal errors are logged rather than reported.
+func (ro *rollout) report(ctx context.Context, t target, canary bool, err error) *instance.InstanceUpdate {
+	if err == nil {
+		outcome := instance.UpdateOutcome_update_succeeded
+		if t.sourceID != "" && ro.deferred() {
+			outcome = instance.UpdateOutcome_update_deferred
+		}
+		return instance.FromUpdate(ro.fschall.ID, t.sourceID, t.identity, canary, outcome, nil)
+	}
+	if _, ok := err.(*errs.ErrInternal); ok {
+		global.Log().Error(ctx, "updating instance",
+			zap.String("identity", t.identity),
+			zap.Error(err),
+		)
+		err = errs.ErrInternalNoSub
+	}
+	return instance.FromUpdate(ro.fschall.ID, t.sourceID, t.identity, canary, instance.UpdateOutcome_update_failed, err)
+}
+
+// toReadiness returns the readiness gate of the health check, if any.
+func toReadiness(hc *HealthCheck) *iac.Readiness {
+	if hc == nil {
+		return nil
+	}
+	r := &iac.Readiness{
+		Timeout:  time.Minute,
+		Interval: 2 * time.Second,
+	}
+	if hc.Timeout != nil {
+		r.Timeout = hc.Timeout.AsDuration()
+	}
+	switch {
+	case hc.GetTcp() != nil:
+		r.Checker = iac.TCPChecker{}
+	case hc.GetHttp() != nil:
+		r.Checker = iac.HTTPChecker{Status: int(hc.GetHttp().Status)}
+	case hc.GetOutput() != nil:
+		r.Checker = iac.OutputChecker{}
+	default:
+		return nil
+	}
+	return r
+}
+
+// splitCanaries splits the targets into the canaries to update first, i.e. the
+// pooled ones along the given percentage of the claimed ones (rounded up), and
+// the others.
+func splitCanaries(targets []target, percent int64) (canaries, others []target) {
+	claimed := int64(0)
+	for _, t := range targets {
+		if t.sourceID != "" {
+			claimed++
+		}
+	}
+	n := (claimed*percent + 99) / 100
+	for _, t := range targets {
+		if t.sourceID == "" {
+			canaries = append(canaries, t)
+			continue
+		}
+		if n > 0 {
+			canaries = append(canaries, t)
+			n--
+			continue
+		}
+		others = append(others, t)
+	}
+	return
+}
@@ -0,0 +1,107 @@
+package challenge
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_U_SplitCanaries(t *testing.T) {
+	t.Parallel()
+
+	targets := []target{
+		{sourceID: "a", identity: "1"},
+		{sourceID: "b", identity: "2"},
+		{sourceID: "c", identity: "3"},
+		{identity: "4"},
+	}
+
+	var tests = map[string]struct {
+		Percent  int64
+		Canaries []string
+	}{
+		"pool-only": {
+			Percent:  0,
+			Canaries: []string{"4"},
+		},
+		"rounded-up": {
+			Percent:  10,
+			Canaries: []string{"1", "4"},
+		},
+		"half": {
+			Percent:  50,
+			Canaries: []string{"1", "2", "4"},
+		},
+		"all": {
+			Percent:  100,
+			Canaries: []string{"1", "2", "3", "4"},
+		},
+	}
+
+	for testname, tt := range tests {
+		t.Run(testname, func(t *testing.T) {
+			assert := assert.New(t)
+
+			canaries, others := splitCanaries(targets, tt.Percent)
+
+			ids := []string{}
+			for _, c := range canaries {
+				ids = append(ids, c.identity)
+			}
+			assert.Equal(tt.Canaries, ids)
+			assert.Len(others, len(targets)-len(canaries))
+		})
+	}
+}
+
+func Test_U_Concurrency(t *testing.T) {
+	t.Parallel()
+
+	var tests = map[string]struct {
+		Strategy       UpdateStrategy
+		Parallelism    int64
+		MaxUnavailable int64
+		Expected       int64
+	}{
+		"unbounded": {
+			Strategy: UpdateStrategy_recreate,
+			Expected: 0,
+		},
+		"parallelism": {
+			Strategy:    UpdateStrategy_update_in_place,
+			Parallelism: 10,
+			Expected:    10,
+		},
+		"max-unavailable-ignored-when-available": {
+			Strategy:       UpdateStrategy_blue_green,
+			Parallelism:    10,
+			MaxUnavailable: 2,
+			Expected:       10,
+		},
+		"max-unavailable-on-recreate": {
+			Strategy:       UpdateStrategy_recreate,
+			Parallelism:    10,
+			MaxUnavailable: 2,
+			Expected:       2,
+		},
+		"max-unavailable-unbounded-parallelism": {
+			Strategy:       UpdateStrategy_recreate,
+			MaxUnavailable: 2,
+			Expected:       2,
+		},
+		"parallelism-below-max-unavailable": {
+			Strategy:       UpdateStrategy_recreate,
+			Parallelism:    1,
+			MaxUnavailable: 2,
+			Expected:       1,
+		},
+	}
+
+	for testname, tt := range tests {
+		t.Run(testname, func(t *testing.T) {
+			assert := assert.New(t)
+
+			assert.Equal(tt.Expected, concurrency(tt.Strategy, tt.Parallelism, tt.MaxUnavailable))
+		})
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"maps"
 	"slices"
+	"strings"
 	"sync"
 	"time"
 
@@ -13,7 +14,6 @@ import (
 	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/multierr"
 	"go.uber.org/zap"
-	"google.golang.org/protobuf/types/known/timestamppb"
 
 	"github.com/ctfer-io/chall-manager/api/v1/common"
 	"github.com/ctfer-io/chall-manager/api/v1/instance"
@@ -21,6 +21,7 @@ import (
 	errs "github.com/ctfer-io/chall-manager/pkg/errors"
 	"github.com/ctfer-io/chall-manager/pkg/fs"
 	"github.com/ctfer-io/chall-manager/pkg/iac"
+	"github.com/ctfer-io/chall-manager/pkg/labels"
 	"github.com/ctfer-io/chall-manager/pkg/lock"
 	"github.com/ctfer-io/chall-manager/pkg/pool"
 )
@@ -28,6 +29,7 @@ import (
 func (store *Store) UpdateChallenge(ctx context.Context, req *UpdateChallengeRequest) (*Challenge, error) {
 	logger := global.Log()
 	ctx = global.WithChallengeID(ctx, req.Id)
+	ctx = iac.WithPriority(ctx, iac.PriorityLow) // rollouts come after players
 	span := trace.SpanFromContext(ctx)
 
 	// 0. Validate request
@@ -35,6 +37,24 @@ func (store *Store) UpdateChallenge(ctx context.Context, req *UpdateChallengeReq
 	if req.Min < 0 || req.Max < 0 || (req.Min > req.Max && req.Max != 0) {
 		return nil, fmt.Errorf("min/max out of bounds: %d/%d", req.Min, req.Max)
 	}
+	if req.MaxInstancesPerSource != nil && *req.MaxInstancesPerSource < 0 {
+		return nil, fmt.Errorf("max instances per source out of bounds: %d", *req.MaxInstancesPerSource)
+	}
+	if req.MaxRenews < 0 || (req.MaxLifetime != nil && req.MaxLifetime.AsDuration() <= 0) {
+		return nil, fmt.Errorf("renewal policy out of bounds: %d renews, %s lifetime", req.MaxRenews, req.MaxLifetime.AsDuration())
+	}
+	if req.CanaryPercent < 0 || req.CanaryPercent > 100 {
+		return nil, fmt.Errorf("canary percent out of bounds: %d", req.CanaryPercent)
+	}
+	if req.Parallelism < 0 || req.MaxUnavailable < 0 {
+		return nil, fmt.Errorf("rollout bounds out of bounds: %d parallelism, %d max unavailable", req.Parallelism, req.MaxUnavailable)
+	}
+	if hc := req.HealthCheck; hc != nil && hc.Timeout != nil && hc.Timeout.AsDuration() <= 0 {
+		return nil, fmt.Errorf("health check timeout must be positive: %s", hc.Timeout.AsDuration())
+	}
+	if err := labels.Validate(req.Labels); err != nil {
+		return nil, err
+	}
 
 	// 1. Lock R TOTW
 	span.AddEvent("lock TOTW")
@@ -124,6 +144,8 @@ func (store *Store) UpdateChallenge(ctx context.Context, req *UpdateChallengeReq
 		return nil, err
 	}
 
+	prev := *fschall
+
 	// 5. Update challenge until/timeout, pooler, or scenario on filesystem
 	updateScenario := false
 	updateAdditional := false
@@ -156,11 +178,35 @@ func (store *Store) UpdateChallenge(ctx context.Context, req *UpdateChallengeReq
 		if slices.Contains(um.Paths, "max") {
 			fschall.Max = req.Max
 		}
+		if slices.Contains(um.Paths, "max_instances_per_source") {
+			fschall.MaxInstancesPerSource = req.MaxInstancesPerSource
+		}
+		if slices.Contains(um.Paths, "max_renews") {
+			fschall.MaxRenews = req.MaxRenews
+		}
+		if slices.Contains(um.Paths, "max_lifetime") {
+			fschall.MaxLifetime = toDuration(req.MaxLifetime)
+		}
+		if slices.Contains(um.Paths, "shared") {
+			fschall.Shared = req.Shared
+		}
+		if slices.Contains(um.Paths, "labels") {
+			fschall.Labels = req.Labels
+		}
+		if slices.Contains(um.Paths, "since") {
+			fschall.Since = toTime(req.Since)
+		}
+		if slices.Contains(um.Paths, "maintenance") {
+			fschall.Maintenance = req.Maintenance
+		}
+	}
+	if fschall.Since != nil && fschall.Until != nil && !fschall.Since.Before(*fschall.Until) {
+		return nil, fmt.Errorf("since must be before until: %s/%s", fschall.Since, fschall.Until)
 	}
 
-	var oldScn *string
+	oldScn := fschall.Scenario
 	if updateScenario {
-		oldScn, fschall.Scenario = &fschall.Scenario, *req.Scenario
+		fschall.Scenario = *req.Scenario
 
 		if err := iac.Validate(ctx, fschall); err != nil {
 			logger.Error(ctx, "validating scenario",
@@ -171,7 +217,12 @@ func (store *Store) UpdateChallenge(ctx context.Context, req *UpdateChallengeReq
 		}
 	}
 
-	// 7. Create "work" and "updated" wait groups for all instances and for all claimed
+	// 6. On dry run, preview the instances rather than updating them
+	if req.DryRun {
+		return previewUpdate(ctx, fschall, req.GetUpdateStrategy())
+	}
+
+	// 7. List the claimed and pooled instances to update
 	logger.Info(ctx, "updating challenge",
 		zap.Bool("scenario", updateScenario),
 		zap.Bool("additional", updateAdditional),
@@ -188,127 +239,102 @@ func (store *Store) UpdateChallenge(ctx context.Context, req *UpdateChallengeReq
 		)
 		return nil, errs.ErrInternalNoSub
 	}
-	claimed := []string{}
+	claimed := []target{}
 	pooled := []string{}
 	for _, ist := range ists {
 		sourceID, _ := fs.LookupClaim(req.Id, ist)
 		isClaimed := sourceID != ""
 		if isClaimed {
-			claimed = append(claimed, ist)
+			claimed = append(claimed, target{sourceID: sourceID, identity: ist})
 		} else {
 			pooled = append(pooled, ist)
 		}
 	}
 
 	delta := pool.NewDelta(fschall.Min, fschall.Max, int64(len(claimed)), int64(len(pooled)))
-	size := len(ists)
-
-	claimedAfterUpdate := make([]string, 0, len(claimed))
-	work := &sync.WaitGroup{}
-	work.Add(size)
-	cerr := make(chan error, size)
-	for _, identity := range claimed {
-		sourceID, _ := fs.LookupClaim(req.Id, identity)
-		go func(work *sync.WaitGroup, cerr chan<- error, sourceID, identity string) {
-			// Track span of loading stack
-			ctx, span := global.Tracer.Start(ctx, "updating-instance", trace.WithAttributes(
-				attribute.String("source_id", sourceID),
-				attribute.String("identity", identity),
-			))
-			defer span.End()
-
-			defer work.Done()
-			ctx = global.WithSourceID(ctx, sourceID)
-			ctx = global.WithIdentity(ctx, identity)
-
-			// 8.a. Lock RW instance
-			ilock, err := common.LockInstance(ctx, req.Id, identity)
-			if err != nil {
-				if ilock.IsCanceled(err) {
-					err = nil
-				}
-				cerr <- err
-				return
-			}
-			if err := ilock.RWLock(ctx); err != nil {
-				if ilock.IsCanceled(err) {
-					err = nil
-				}
-				cerr <- err
-				return
-			}
-			defer func(lock lock.RWLock) {
-				if err := lock.RWUnlock(context.WithoutCancel(ctx)); err != nil {
-					err := &errs.ErrInternal{Sub: err}
-					logger.Error(ctx, "instance RW unlock", zap.Error(err))
-				}
-			}(ilock)
-
-			fsist, err := fs.LoadInstance(req.Id, identity)
-			if err != nil {
-				cerr <- err
-				return
-			}
-
-			// 8.c. Mirror instance's "until" based on the challenge
-			fsist.Until = common.ComputeUntil(fschall.Until, fschall.Timeout)
 
-			// 8.d. If scenario is not nil, update it
-			scn := fschall.Scenario
-			if updateScenario {
-				scn = *oldScn
-			}
-
-			// Keep track of who is the owner of the instance
-			oldID := fsist.Identity
+	// The instances are stamped with the revision they are updated to, such that
+	// those lagging behind (e.g. after a deferred update) are recognized.
+	// The revision is recorded before any instance or the challenge carries it,
+	// thus numbers are never reused even if the update is aborted or fails.
+	last, err := fs.LastRevision(req.Id)
+	if err != nil {
+		logger.Error(ctx, "loading challenge revisions",
+			zap.Error(err),
+		)
+		return nil, errs.ErrInternalNoSub
+	}
+	if last == 0 {
+		// The challenge predates revisions, record its current configuration
+		// as a baseline such that it can be rolled back to.
+		if err := appendRevision(ctx, &prev, ""); err != nil {
+			logger.Error(ctx, "recording challenge baseline revision",
+				zap.Error(err),
+			)
+			return nil, errs.ErrInternalNoSub
+		}
+		last = 1
+	}
+	fschall.Revision = last + 1
+	if err := appendRevision(ctx, fschall, req.GetUpdateStrategy().String()); err != nil {
+		logger.Error(ctx, "recording challenge revision",
+			zap.Error(err),
+		)
+		return nil, errs.ErrInternalNoSub
+	}
 
-			// Then update if necessary
-			if updateScenario || updateAdditional {
-				if err := iac.Update(ctx, scn, req.UpdateStrategy.String(), fschall, fsist); err != nil {
-					cerr <- err
-					return
-				}
-			}
+	ro := &rollout{
+		fschall:  fschall,
+		prevScn:  oldScn,
+		prevRev:  prev.Revision,
+		update:   updateScenario || updateAdditional,
+		strategy: *req.UpdateStrategy,
+	}
+	ro.bound(concurrency(*req.UpdateStrategy, req.Parallelism, req.MaxUnavailable))
+	if r := toReadiness(req.HealthCheck); r != nil {
+		ctx = iac.WithReadiness(ctx, r)
+	}
+	targets := slices.Clone(claimed)
+	for _, identity := range pooled[delta.Delete:] {
+		targets = append(targets, target{identity: identity})
+	}
 
-			// Save potentially updated instance
-			newIst := fsist.Identity
-			if err := fsist.Save(); err != nil {
-				cerr <- err
-				return
-			}
+	// 8. With the canary strategy, first update the canaries. If any fails, revert
+	//    them and leave the challenge as it was, all changes included, then abort.
+	updates := []*instance.InstanceUpdate{}
+	if *req.UpdateStrategy == UpdateStrategy_canary && ro.update {
+		canaries, others := splitCanaries(targets, req.CanaryPercent)
+		logger.Info(ctx, "updating canaries",
+			zap.Int("canaries", len(canaries)),
+			zap.Int("others", len(others)),
+		)
 
-			// (Re-)claim the instance (e.g. can be another one with recreate)
-			if err := fsist.Claim(sourceID); err != nil {
-				if _, ok := err.(*fs.ErrAlreadyClaimed); !ok {
-					cerr <- err
-					return
-				}
+		cerrs := ro.run(ctx, canaries)
+		if slices.ContainsFunc(cerrs, func(err error) bool { return err != nil }) {
+			logger.Error(ctx, "canaries failed, reverting them",
+				zap.Error(multierr.Combine(cerrs...)),
+			)
+			updates := ro.revert(ctx, &prev, canaries, cerrs, others)
+			identities := make([]string, 0, len(claimed))
+			for _, t := range claimed {
+				identities = append(identities, t.identity)
 			}
-
-			claimedAfterUpdate = append(claimedAfterUpdate, newIst)
-
-			if oldID != newIst {
-				// Delete old instance (unused resources)
-				oldIst := &fs.Instance{
-					ChallengeID: req.Id,
-					Identity:    oldID,
-				}
-				if err := oldIst.Delete(); err != nil {
-					cerr <- err
-					return
-				}
+			chall, err := updatedChallenge(ctx, &prev, identities, updates)
+			if err != nil {
+				return nil, err
 			}
-
-			// 8.e. Unlock RW instance
-			//      -> defered after 8.a. (fault-tolerance)
-			// 8.f. done in the "work" wait group
-			///     -> defered at the beginning of goroutine
-		}(work, cerr, sourceID, identity)
+			return nil, canariesFailed(chall)
+		}
+		for _, t := range canaries {
+			updates = append(updates, ro.report(ctx, t, true, nil))
+		}
+		targets = others
 	}
 
-	// Create new instances if there is no until configured or
-	// current calls happens before this until date.
-	if fschall.Until == nil || time.Now().Before(*fschall.Until) {
+	// 9. Create new instances if there is no until configured or
+	//    current calls happens before this until date, and the challenge
+	//    is not under maintenance.
+	if (fschall.Until == nil || time.Now().Before(*fschall.Until)) && !fschall.Maintenance {
 		for range delta.Create {
 			// The pool will spin instances and make them available ASAP,
 			// but we don't have the time to wait for it now.
@@ -316,6 +342,9 @@ func (store *Store) UpdateChallenge(ctx context.Context, req *UpdateChallengeReq
 		}
 	}
 
+	work := &sync.WaitGroup{}
+	work.Add(int(delta.Delete))
+	cerr := make(chan error, delta.Delete)
 	for _, identity := range pooled[:delta.Delete] {
 		go func(work *sync.WaitGroup, cerr chan<- error, identity string) {
 			ctx, span := global.Tracer.Start(ctx, "delete-instance", trace.WithAttributes(
@@ -324,6 +353,7 @@ func (store *Store) UpdateChallenge(ctx context.Context, req *UpdateChallengeReq
 			defer span.End()
 
 			defer work.Done()
+			defer ro.acquire()()
 			ctx = global.WithIdentity(ctx, identity)
 
 			fsist, err := fs.LoadInstance(req.Id, identity)
@@ -332,7 +362,7 @@ func (store *Store) UpdateChallenge(ctx context.Context, req *UpdateChallengeReq
 				return
 			}
 
-			stack, err := iac.LoadStack(ctx, fschall.Scenario, identity)
+			stack, err := iac.LoadStack(ctx, fsist.RunningScenario(ro.prevScn), identity)
 			if err != nil {
 				cerr <- err
 				return
@@ -361,41 +391,6 @@ func (store *Store) UpdateChallenge(ctx context.Context, req *UpdateChallengeReq
 		}(work, cerr, identity)
 	}
 
-	// Update iif required to do so, elseway do nothing
-	for _, identity := range pooled[delta.Delete:] {
-		go func(work *sync.WaitGroup, cerr chan<- error, identity string) {
-			ctx, span := global.Tracer.Start(ctx, "update-instance", trace.WithAttributes(
-				attribute.String("identity", identity),
-			))
-			defer span.End()
-
-			defer work.Done()
-			ctx = global.WithIdentity(ctx, identity)
-
-			fsist, err := fs.LoadInstance(req.Id, identity)
-			if err != nil {
-				cerr <- err
-				return
-			}
-
-			newScn := fschall.Scenario
-			if updateScenario {
-				newScn = *oldScn
-			}
-			if updateScenario || updateAdditional {
-				if err := iac.Update(ctx, newScn, req.UpdateStrategy.String(), fschall, fsist); err != nil {
-					cerr <- err
-					return
-				}
-			}
-
-			if err := fsist.Save(); err != nil {
-				cerr <- err
-				return
-			}
-		}(work, cerr, identity)
-	}
-
 	if err := fschall.Save(); err != nil {
 		if err, ok := err.(*errs.ErrInternal); ok {
 			logger.Error(ctx, "exporting challenge information to filesystem",
@@ -406,7 +401,23 @@ func (store *Store) UpdateChallenge(ctx context.Context, req *UpdateChallengeReq
 		return nil, err
 	}
 
-	// 10. Once all "work" done, return response or error if any
+	// 10. Update the (other) instances. Failures are reported per instance.
+	terrs := ro.run(ctx, targets)
+	failed := 0
+	for i, t := range targets {
+		if terrs[i] != nil {
+			failed++
+		}
+		updates = append(updates, ro.report(ctx, t, false, terrs[i]))
+	}
+	if failed != 0 {
+		logger.Warn(ctx, "some instances failed to update",
+			zap.Int("failed", failed),
+			zap.Int("instances", len(targets)),
+		)
+	}
+
+	// 11. Once all work done, return response or error if any
 	work.Wait()
 
 	close(cerr)
@@ -438,15 +449,22 @@ func (store *Store) UpdateChallenge(ctx context.Context, req *UpdateChallengeReq
 		)
 		return nil, errs.ErrInternalNoSub
 	}
-
 	logger.Info(ctx, "challenge updated successfully")
 
-	oists := make([]*instance.Instance, 0, len(claimedAfterUpdate))
-	for _, identity := range claimedAfterUpdate {
-		sourceID, _ := fs.LookupClaim(req.Id, identity)
+	return updatedChallenge(ctx, fschall, ro.claimed, updates)
+}
+
+// updatedChallenge builds the response of an update of the challenge, with its
+// claimed instances and the outcome of each instance update.
+func updatedChallenge(ctx context.Context, fschall *fs.Challenge, claimed []string, updates []*instance.InstanceUpdate) (*Challenge, error) {
+	logger := global.Log()
+
+	oists := make([]*instance.Instance, 0, len(claimed))
+	for _, identity := range claimed {
+		sourceID, _ := fs.LookupClaim(fschall.ID, identity)
 		ctx := global.WithSourceID(ctx, sourceID)
 
-		fsist, err := fs.LoadInstance(req.Id, identity)
+		fsist, err := fs.LoadInstance(fschall.ID, identity)
 		if err != nil {
 			if err, ok := err.(*errs.ErrInternal); ok {
 				logger.Error(ctx, "loading instance",
@@ -457,30 +475,15 @@ func (store *Store) UpdateChallenge(ctx context.Context, req *UpdateChallengeReq
 			return nil, err
 		}
 
-		var until *timestamppb.Timestamp
-		if fsist.Until != nil {
-			until = timestamppb.New(*fsist.Until)
-		}
-		oists = append(oists, &instance.Instance{
-			ChallengeId:    req.Id,
-			SourceId:       sourceID,
-			Since:          timestamppb.New(fsist.Since),
-			LastRenew:      timestamppb.New(fsist.LastRenew),
-			Until:          until,
-			ConnectionInfo: fsist.ConnectionInfo,
-			Flag: func() *string { // kept for retrocompatibility enough time for public migration
-				if len(fsist.Flags) == 1 {
-					return &fsist.Flags[0]
-				}
-				return nil
-			}(),
-			Flags:      fsist.Flags,
-			Additional: fsist.Additional,
-		})
+		oists = append(oists, instance.FromFS(fsist, sourceID))
 	}
 
+	slices.SortFunc(updates, func(a, b *instance.InstanceUpdate) int {
+		return strings.Compare(a.GetIdentity(), b.GetIdentity())
+	})
+
 	return &Challenge{
-		Id:         req.Id,
+		Id:         fschall.ID,
 		Scenario:   fschall.Scenario,
 		Additional: fschall.Additional,
 		Min:        fschall.Min,
@@ -488,5 +491,14 @@ func (store *Store) UpdateChallenge(ctx context.Context, req *UpdateChallengeReq
 		Timeout:    toPBDuration(fschall.Timeout),
 		Until:      toPBTimestamp(fschall.Until),
 		Instances:  oists,
+
+		MaxInstancesPerSource: fschall.MaxInstancesPerSource,
+		MaxRenews:             fschall.MaxRenews,
+		MaxLifetime:           toPBDuration(fschall.MaxLifetime),
+		Shared:                fschall.Shared,
+		Labels:                fschall.Labels,
+		Since:                 toPBTimestamp(fschall.Since),
+		Maintenance:           fschall.Maintenance,
+		Updates:               updates,
 	}, nil
 }
@@ -0,0 +1,84 @@
+package common
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// Collector is an in-memory server stream, to serve a streaming query as a
+// unary one: it collects the streamed messages along the headers.
+// It is safe for concurrent use, e.g. through a QueryServer.
+type Collector[T any] struct {
+	ctx context.Context
+
+	mx     sync.Mutex
+	items  []*T
+	header metadata.MD
+}
+
+var _ grpc.ServerStreamingServer[struct{}] = (*Collector[struct{}])(nil)
+
+// NewCollector creates a collector for a query served within the given context.
+func NewCollector[T any](ctx context.Context) *Collector[T] {
+	return &Collector[T]{
+		ctx:    ctx,
+		header: metadata.MD{},
+	}
+}
+
+// Items returns the collected messages, in the order they have been sent.
+func (c *Collector[T]) Items() []*T {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	return c.items
+}
+
+// Header returns the first value of the header of the given key, if set.
+func (c *Collector[T]) Header(key string) (string, bool) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	if v := c.header.Get(key); len(v) != 0 {
+		return v[0], true
+	}
+	return "", false
+}
+
+func (c *Collector[T]) Send(m *T) error {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	c.items = append(c.items, m)
+	return nil
+}
+
+func (c *Collector[T]) SendMsg(m any) error {
+	return c.Send(m.(*T))
+}
+
+func (c *Collector[T]) RecvMsg(any) error {
+	return io.EOF
+}
+
+func (c *Collector[T]) SetHeader(md metadata.MD) error {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	c.header = metadata.Join(c.header, md)
+	return nil
+}
+
+func (c *Collector[T]) SendHeader(md metadata.MD) error {
+	return c.SetHeader(md)
+}
+
+func (c *Collector[T]) SetTrailer(metadata.MD) {}
+
+func (c *Collector[T]) Context() context.Context {
+	return c.ctx
+}
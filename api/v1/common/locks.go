@@ -19,3 +19,7 @@ func LockChallenge(ctx context.Context, challengeID string) (lock.RWLock, error)
 func LockInstance(ctx context.Context, challengeID, identity string) (lock.RWLock, error) {
 	return lock.NewRWLock(ctx, filepath.Join("chall", fs.Hash(challengeID), "src", fs.Hash(identity)))
 }
+
+func LockSource(ctx context.Context, sourceID string) (lock.RWLock, error) {
+	return lock.NewRWLock(ctx, filepath.Join("src", fs.Hash(sourceID)))
+}
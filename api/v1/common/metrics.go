@@ -14,6 +14,12 @@ var (
 
 	instancesUDCounter     metric.Int64UpDownCounter
 	instancesUDCounterOnce sync.Once
+
+	transfersCounter     metric.Int64Counter
+	transfersCounterOnce sync.Once
+
+	driftsCounter     metric.Int64Counter
+	driftsCounterOnce sync.Once
 )
 
 func ChallengesUDCounter() metric.Int64UpDownCounter {
@@ -42,6 +48,32 @@ func InstancesUDCounter() metric.Int64UpDownCounter {
 	return instancesUDCounter
 }
 
+func TransfersCounter() metric.Int64Counter {
+	transfersCounterOnce.Do(func() {
+		cnt, err := global.Meter.Int64Counter("instance_transfers",
+			metric.WithDescription("The number of instances transferred from a source to another"),
+		)
+		if err != nil {
+			panic(err)
+		}
+		transfersCounter = cnt
+	})
+	return transfersCounter
+}
+
+func DriftsCounter() metric.Int64Counter {
+	driftsCounterOnce.Do(func() {
+		cnt, err := global.Meter.Int64Counter("instance_drifts",
+			metric.WithDescription("The number of instances found drifted from their state when refreshed"),
+		)
+		if err != nil {
+			panic(err)
+		}
+		driftsCounter = cnt
+	})
+	return driftsCounter
+}
+
 func InstanceAttrs(challID, sourceID string, pool bool) attribute.Set {
 	attrs := []attribute.KeyValue{
 		attribute.String("challenge", challID),
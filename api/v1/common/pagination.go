@@ -0,0 +1,64 @@
+package common
+
+import (
+	"encoding/base64"
+	"errors"
+	"sort"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+var (
+	ErrInvalidPageToken = errors.New("invalid page token")
+	ErrInvalidPageSize  = errors.New("page size must be positive")
+)
+
+// Paginate returns the page of items that follows the one the token refers to,
+// and the token of the next page if there are more items.
+// Items must be sorted by their key, which must be unique.
+// If size is 0, all the remaining items are returned.
+func Paginate[T any](items []T, key func(T) string, size int64, token string) ([]T, string, error) {
+	if size < 0 {
+		return nil, "", ErrInvalidPageSize
+	}
+	if token != "" {
+		last, err := DecodePageToken(token)
+		if err != nil {
+			return nil, "", err
+		}
+		items = items[sort.Search(len(items), func(i int) bool {
+			return key(items[i]) > last
+		}):]
+	}
+	if size == 0 || int64(len(items)) <= size {
+		return items, "", nil
+	}
+	page := items[:size]
+	return page, EncodePageToken(key(page[size-1])), nil
+}
+
+// EncodePageToken returns the token of the page that follows the item of the
+// given key.
+func EncodePageToken(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+// DecodePageToken returns the key of the last item of the previous page, or an
+// empty key if there is no token.
+func DecodePageToken(token string) (string, error) {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return "", ErrInvalidPageToken
+	}
+	return string(b), nil
+}
+
+// SetNextPageToken sends the token of the next page in the "next-page-token"
+// header, if any. It must be called before streaming the page.
+func SetNextPageToken(server grpc.ServerStream, token string) error {
+	if token == "" {
+		return nil
+	}
+	return server.SetHeader(metadata.Pairs("next-page-token", token))
+}
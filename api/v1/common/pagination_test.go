@@ -0,0 +1,46 @@
+package common_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ctfer-io/chall-manager/api/v1/common"
+)
+
+func Test_U_Paginate(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	items := []string{"a", "b", "c", "d", "e"}
+	key := func(s string) string { return s }
+
+	// Walk through all pages
+	got := []string{}
+	token := ""
+	for {
+		page, next, err := common.Paginate(items, key, 2, token)
+		require.NoError(err)
+		assert.LessOrEqual(len(page), 2)
+
+		got = append(got, page...)
+		if next == "" {
+			break
+		}
+		token = next
+	}
+	assert.Equal(items, got)
+
+	// No size means everything
+	page, next, err := common.Paginate(items, key, 0, "")
+	require.NoError(err)
+	assert.Equal(items, page)
+	assert.Empty(next)
+
+	// Invalid tokens are refused
+	_, _, err = common.Paginate(items, key, 2, "not base64 !")
+	assert.ErrorIs(err, common.ErrInvalidPageToken)
+}
@@ -0,0 +1,39 @@
+package instance
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/ctfer-io/chall-manager/global"
+	errs "github.com/ctfer-io/chall-manager/pkg/errors"
+	"github.com/ctfer-io/chall-manager/pkg/fs"
+	"github.com/ctfer-io/chall-manager/pkg/otel"
+)
+
+// audit records an operation in the journal of the challenge, best effort.
+// The instance identity is read from the context, as it may only be known
+// once the operation is done.
+func audit(ctx context.Context, op fs.Operation, challID, sourceID string, start time.Time, opErr error) {
+	entry := &fs.JournalEntry{
+		At:        start,
+		Operation: op,
+		SourceID:  sourceID,
+		Identity:  global.IdentityFrom(ctx),
+		Outcome:   fs.OutcomeSuccess,
+		Duration:  time.Since(start),
+		Caller:    otel.CallerFromContext(ctx),
+	}
+	if opErr != nil {
+		entry.Outcome = fs.OutcomeFailure
+		entry.Error = opErr.Error()
+	}
+	if err := fs.AppendJournal(challID, entry); err != nil {
+		// The challenge may not exist, thus there is nothing to audit
+		if _, ok := err.(*errs.ErrChallengeExist); ok {
+			return
+		}
+		global.Log().Error(ctx, "appending to challenge journal", zap.Error(err))
+	}
+}
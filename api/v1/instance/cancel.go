@@ -0,0 +1,141 @@
+package instance
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/ctfer-io/chall-manager/api/v1/common"
+	"github.com/ctfer-io/chall-manager/global"
+	errs "github.com/ctfer-io/chall-manager/pkg/errors"
+	"github.com/ctfer-io/chall-manager/pkg/fs"
+	"github.com/ctfer-io/chall-manager/pkg/iac"
+	"github.com/ctfer-io/chall-manager/pkg/lock"
+)
+
+func (man *Manager) CancelInstanceOperation(ctx context.Context, req *CancelInstanceOperationRequest) (_ *emptypb.Empty, err error) {
+	logger := global.Log()
+	ctx = global.WithChallengeID(ctx, req.ChallengeId)
+	ctx = global.WithSourceID(ctx, req.SourceId)
+	span := trace.SpanFromContext(ctx)
+	defer func(start time.Time) {
+		audit(ctx, fs.OpCancel, req.ChallengeId, req.SourceId, start, err)
+	}(time.Now())
+
+	// 1. Lock R TOTW
+	span.AddEvent("lock TOTW")
+	totw, err := common.LockTOTW(ctx)
+	if err != nil {
+		if totw.IsCanceled(err) {
+			return nil, nil
+		}
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "build TOTW lock", zap.Error(err))
+		return nil, errs.ErrInternalNoSub
+	}
+	if err := totw.RLock(ctx); err != nil {
+		if totw.IsCanceled(err) {
+			return nil, nil
+		}
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "TOTW R lock", zap.Error(err))
+		return nil, errs.ErrInternalNoSub
+	}
+	span.AddEvent("locked TOTW")
+
+	// 2. Lock R challenge
+	clock, err := common.LockChallenge(ctx, req.ChallengeId)
+	if err != nil {
+		if clock.IsCanceled(err) {
+			// If canceled, we need to recover
+			if err := totw.RUnlock(context.WithoutCancel(ctx)); err != nil {
+				err := &errs.ErrInternal{Sub: err}
+				logger.Error(ctx, "recovering from build challenge lock", zap.Error(err))
+				return nil, errs.ErrInternalNoSub
+			}
+			return nil, nil // recovery is successful, we can quit safely
+		}
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "build challenge lock", zap.Error(multierr.Combine(
+			totw.RUnlock(context.WithoutCancel(ctx)),
+			err,
+		)))
+		return nil, errs.ErrInternalNoSub
+	}
+	if err := clock.RLock(ctx); err != nil {
+		if clock.IsCanceled(err) {
+			// If canceled, we need to recover
+			if err := totw.RUnlock(context.WithoutCancel(ctx)); err != nil {
+				err := &errs.ErrInternal{Sub: err}
+				logger.Error(ctx, "recovering from challenge R lock", zap.Error(err))
+				return nil, errs.ErrInternalNoSub
+			}
+			return nil, nil // recovery is successful, we can quit safely
+		}
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "challenge R lock", zap.Error(multierr.Combine(
+			totw.RUnlock(context.WithoutCancel(ctx)),
+			err,
+		)))
+		return nil, errs.ErrInternalNoSub
+	}
+	defer func(lock lock.RWLock) {
+		if err := lock.RUnlock(context.WithoutCancel(ctx)); err != nil {
+			err := &errs.ErrInternal{Sub: err}
+			logger.Error(ctx, "challenge R unlock", zap.Error(err))
+		}
+	}(clock)
+
+	// 3. Unlock R TOTW
+	if err := totw.RUnlock(context.WithoutCancel(ctx)); err != nil {
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "TOTW R unlock", zap.Error(err))
+		return nil, errs.ErrInternalNoSub
+	}
+	span.AddEvent("unlocked TOTW")
+
+	// 4. If challenge/instance does not exist, return error
+	if err := fs.CheckChallenge(req.ChallengeId); err != nil {
+		return nil, err
+	}
+	id, err := fs.FindInstance(req.ChallengeId, req.SourceId)
+	if err != nil {
+		if _, ok := err.(*errs.ErrInstanceExist); ok {
+			return nil, err
+		}
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "finding instance",
+			zap.Error(err),
+		)
+		return nil, errs.ErrInternalNoSub
+	}
+
+	// 5. Cancel the in-flight operation, and wait for it to clean up.
+	//    The instance is not locked as the operation needs it to save its outcome.
+	ctx = global.WithIdentity(ctx, id)
+	canceled, err := iac.Cancel(ctx, req.ChallengeId, id)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, nil
+		}
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "canceling instance operation", zap.Error(err))
+		return nil, errs.ErrInternalNoSub
+	}
+	if !canceled {
+		return nil, &errs.ErrNoOperation{
+			ChallengeID: req.ChallengeId,
+			Identity:    id,
+		}
+	}
+	logger.Info(ctx, "canceled instance operation")
+
+	// 6. Unlock R challenge
+	//    -> defered after 2 (fault-tolerance)
+
+	return nil, nil
+}
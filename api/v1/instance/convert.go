@@ -0,0 +1,194 @@
+package instance
+
+import (
+	"time"
+
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/ctfer-io/chall-manager/pkg/events"
+	"github.com/ctfer-io/chall-manager/pkg/fs"
+	"github.com/ctfer-io/chall-manager/pkg/iac"
+)
+
+// FromFS converts a filesystem instance, claimed by the given source (the
+// group that owns it if shared), to its API representation.
+// Secret outputs values are not returned, see fromOwnedFS for the owner.
+func FromFS(fsist *fs.Instance, sourceID string) *Instance {
+	return fromFS(fsist, sourceID, false)
+}
+
+// fromOwnedFS converts a filesystem instance to its API representation for
+// the source that owns it, i.e. with its secret outputs values.
+func fromOwnedFS(fsist *fs.Instance, sourceID string) *Instance {
+	return fromFS(fsist, sourceID, true)
+}
+
+func fromFS(fsist *fs.Instance, sourceID string, secrets bool) *Instance {
+	var until *timestamppb.Timestamp
+	if fsist.Until != nil {
+		until = timestamppb.New(*fsist.Until)
+	}
+	var reason *string
+	if fsist.Reason != "" {
+		reason = &fsist.Reason
+	}
+	return &Instance{
+		ChallengeId:    fsist.ChallengeID,
+		SourceId:       sourceID,
+		Since:          timestamppb.New(fsist.Since),
+		LastRenew:      timestamppb.New(fsist.LastRenew),
+		Until:          until,
+		ConnectionInfo: fsist.ConnectionInfo,
+		Flag: func() *string { // kept for retrocompatibility enough time for public migration
+			if len(fsist.Flags) == 1 {
+				return &fsist.Flags[0]
+			}
+			return nil
+		}(),
+		Flags:      fsist.Flags,
+		Additional: fsist.Additional,
+		State:      toState(fsist.Status),
+		Reason:     reason,
+		Renews:     fsist.Renews,
+		Members:    fsist.Members,
+		Outputs:    toOutputs(fsist.Outputs, secrets),
+		Revision:   fsist.Revision,
+	}
+}
+
+func toOutputs(outs fs.Outputs, secrets bool) map[string]*InstanceOutput {
+	if len(outs) == 0 {
+		return nil
+	}
+	pbouts := make(map[string]*InstanceOutput, len(outs))
+	for k, out := range outs {
+		pbout := &InstanceOutput{
+			Secret: out.Secret,
+		}
+		if !out.Secret || secrets {
+			// Values are decoded from JSON, thus are always convertible
+			pbout.Value, _ = structpb.NewValue(out.Value)
+		}
+		pbouts[k] = pbout
+	}
+	return pbouts
+}
+
+func toState(status fs.InstanceStatus) InstanceState {
+	switch status {
+	case fs.StatusProvisioning:
+		return InstanceState_provisioning
+	case fs.StatusFailed:
+		return InstanceState_failed
+	case fs.StatusDeleting:
+		return InstanceState_deleting
+	default:
+		return InstanceState_ready
+	}
+}
+
+func fromEvent(ev events.Event) *InstanceEvent {
+	ist := *ev.Instance
+	ist.Members = ev.Members // not carried along the instance through etcd
+	return &InstanceEvent{
+		Type:     toEventType(ev.Type),
+		Instance: FromFS(&ist, ev.SourceID),
+		At:       timestamppb.New(ev.At),
+	}
+}
+
+func toEventType(typ events.Type) InstanceEventType {
+	switch typ {
+	case events.Claimed:
+		return InstanceEventType_claimed
+	case events.Ready:
+		return InstanceEventType_up
+	case events.Failed:
+		return InstanceEventType_fail
+	case events.Renewed:
+		return InstanceEventType_renewed
+	case events.Updated:
+		return InstanceEventType_updated
+	case events.Reset:
+		return InstanceEventType_reset
+	case events.Deleted:
+		return InstanceEventType_deleted
+	case events.Transferred:
+		return InstanceEventType_transferred
+	default:
+		return InstanceEventType_created
+	}
+}
+
+func fromJournalEntry(entry *fs.JournalEntry) *InstanceAuditEntry {
+	var e *string
+	if entry.Error != "" {
+		e = &entry.Error
+	}
+	return &InstanceAuditEntry{
+		At:              timestamppb.New(entry.At),
+		Operation:       string(entry.Operation),
+		SourceId:        entry.SourceID,
+		Identity:        entry.Identity,
+		Outcome:         string(entry.Outcome),
+		Error:           e,
+		Duration:        durationpb.New(entry.Duration),
+		CallerFunction:  entry.Caller.Function,
+		CallerAddress:   entry.Caller.Address,
+		CallerUserAgent: entry.Caller.UserAgent,
+	}
+}
+
+func toTime(ts *timestamppb.Timestamp) *time.Time {
+	if ts == nil {
+		return nil
+	}
+	t := ts.AsTime()
+	return &t
+}
+
+// FromDiff converts the preview of an instance to its API representation.
+// If the preview failed, the reason is reported instead of the changes.
+func FromDiff(challID, sourceID, identity string, diff *iac.Diff, err error) *InstancePreview {
+	prev := &InstancePreview{
+		ChallengeId: challID,
+	}
+	if sourceID != "" {
+		prev.SourceId = &sourceID
+	}
+	if identity != "" {
+		prev.Identity = &identity
+	}
+	if err != nil {
+		reason := err.Error()
+		prev.Error = &reason
+		return prev
+	}
+	prev.Creates = diff.Creates
+	prev.Updates = diff.Updates
+	prev.Replaces = diff.Replaces
+	prev.Deletes = diff.Deletes
+	prev.Sames = diff.Sames
+	return prev
+}
+
+// FromUpdate converts the outcome of an instance update to its API representation.
+// If the update failed, the reason is reported along.
+func FromUpdate(challID, sourceID, identity string, canary bool, outcome UpdateOutcome, err error) *InstanceUpdate {
+	upd := &InstanceUpdate{
+		ChallengeId: challID,
+		Identity:    identity,
+		Canary:      canary,
+		Outcome:     outcome,
+	}
+	if sourceID != "" {
+		upd.SourceId = &sourceID
+	}
+	if err != nil {
+		reason := err.Error()
+		upd.Error = &reason
+	}
+	return upd
+}
@@ -2,28 +2,33 @@ package instance
 
 import (
 	context "context"
-	"errors"
 	"time"
 
+	"github.com/pkg/errors"
 	"go.opentelemetry.io/otel/metric"
 	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/multierr"
 	"go.uber.org/zap"
-	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
 
 	"github.com/ctfer-io/chall-manager/api/v1/common"
 	"github.com/ctfer-io/chall-manager/global"
 	errs "github.com/ctfer-io/chall-manager/pkg/errors"
+	"github.com/ctfer-io/chall-manager/pkg/events"
 	"github.com/ctfer-io/chall-manager/pkg/fs"
 	"github.com/ctfer-io/chall-manager/pkg/iac"
 	"github.com/ctfer-io/chall-manager/pkg/identity"
+	"github.com/ctfer-io/chall-manager/pkg/lock"
 )
 
-func (man *Manager) CreateInstance(ctx context.Context, req *CreateInstanceRequest) (*Instance, error) {
+func (man *Manager) CreateInstance(ctx context.Context, req *CreateInstanceRequest) (_ *Instance, err error) {
 	logger := global.Log()
 	ctx = global.WithChallengeID(ctx, req.ChallengeId)
 	ctx = global.WithSourceID(ctx, req.SourceId)
+	ctx = iac.WithPriority(ctx, iac.PriorityHigh) // players are served first
 	span := trace.SpanFromContext(ctx)
+	defer func(start time.Time) {
+		audit(ctx, fs.OpCreate, req.ChallengeId, req.SourceId, start, err)
+	}(time.Now())
 
 	// 1. Lock R TOTW
 	span.AddEvent("lock TOTW")
@@ -96,8 +101,8 @@ func (man *Manager) CreateInstance(ctx context.Context, req *CreateInstanceReque
 	}
 	span.AddEvent("unlocked TOTW")
 
-	// 2. If challenge does not exist, is expired, or already has an instance
-	// for the given source, return error.
+	// 2. If challenge does not exist, is expired, not opened, under maintenance,
+	// or already has an instance for the given source, return error.
 	fschall, err := fs.LoadChallenge(req.ChallengeId)
 	if err != nil {
 		err = multierr.Combine(
@@ -119,6 +124,12 @@ func (man *Manager) CreateInstance(ctx context.Context, req *CreateInstanceReque
 		}
 		return nil, errors.New("challenge is already expired")
 	}
+	if err := fschall.Unavailable(time.Now()); err != nil {
+		if err := clock.RUnlock(context.WithoutCancel(ctx)); err != nil {
+			logger.Error(ctx, "unlocking R challenge", zap.Error(err))
+		}
+		return nil, err
+	}
 	if _, err := fs.FindInstance(req.ChallengeId, req.SourceId); err == nil {
 		if err := clock.RUnlock(context.WithoutCancel(ctx)); err != nil {
 			logger.Error(ctx, "unlocking R challenge", zap.Error(err))
@@ -129,6 +140,18 @@ func (man *Manager) CreateInstance(ctx context.Context, req *CreateInstanceReque
 			Exist:       true,
 		}
 	}
+	releaseQuota, err := lockQuota(ctx, fschall, req.SourceId)
+	if err != nil {
+		if err := clock.RUnlock(context.WithoutCancel(ctx)); err != nil {
+			logger.Error(ctx, "unlocking R challenge", zap.Error(err))
+		}
+		if _, ok := err.(*errs.ErrInternal); ok {
+			logger.Error(ctx, "checking source quota", zap.Error(err))
+			return nil, errs.ErrInternalNoSub
+		}
+		return nil, err
+	}
+	defer releaseQuota() // released once the instance is claimed, or on failure
 
 	// If there are instances in pool, claim one, else deploy
 	ists, err := fs.ListInstances(req.ChallengeId)
@@ -181,6 +204,7 @@ func (man *Manager) CreateInstance(ctx context.Context, req *CreateInstanceReque
 			)
 			return nil, errs.ErrInternalNoSub
 		}
+		releaseQuota()
 
 		// Lock RW instance
 		ctx = global.WithSourceID(ctx, req.SourceId)
@@ -238,12 +262,55 @@ func (man *Manager) CreateInstance(ctx context.Context, req *CreateInstanceReque
 			return nil, errs.ErrInternalNoSub
 		}
 
-		// Update times and stack
-		fsist.Until = common.ComputeUntil(fschall.Until, fschall.Timeout)
-		fsist.LastRenew = time.Now()
+		// Update times and stack, the instance lifetime starts once claimed
+		fsist.Start(time.Now(), common.ComputeUntil(fschall.Until, fschall.Timeout))
 		if len(req.Additional) != 0 {
 			fsist.Additional = req.Additional
-			if err := iac.Update(ctx, fschall.Scenario, "", fschall, fsist); err != nil {
+
+			if req.Async {
+				// Register the instance as provisioning, then update it in background.
+				// The challenge lock is released by the background routine once done.
+				fsist.Status = fs.StatusProvisioning
+				if err := fsist.Save(); err != nil {
+					logger.Error(ctx, "saving challenge instance on filesystem",
+						zap.Error(multierr.Combine(
+							clock.RUnlock(context.WithoutCancel(ctx)),
+							ilock.RWUnlock(context.WithoutCancel(ctx)),
+							err,
+						)),
+					)
+					return nil, errs.ErrInternalNoSub
+				}
+				if err := ilock.RWUnlock(context.WithoutCancel(ctx)); err != nil {
+					logger.Error(ctx, "instance RW unlock",
+						zap.Error(multierr.Combine(
+							clock.RUnlock(context.WithoutCancel(ctx)),
+							err,
+						)),
+					)
+					return nil, errs.ErrInternalNoSub
+				}
+
+				go func(ctx context.Context, fsist fs.Instance) {
+					defer unlockChallenge(ctx, clock)
+
+					// Track the operation, such that it can be canceled e.g. if the instance is deleted meanwhile
+					start := time.Now()
+					tctx, done := iac.Track(ctx, fsist.ChallengeID, fsist.Identity)
+					err := iac.Update(tctx, fsist.RunningScenario(fschall.Scenario), "", fschall, &fsist)
+					done()
+					if err != nil {
+						logger.Error(ctx, "updating pooled instance", zap.Error(err))
+					}
+					_ = saveOutcome(ctx, &fsist, req.SourceId, err)
+					audit(ctx, fs.OpProvision, req.ChallengeId, req.SourceId, start, err)
+				}(context.WithoutCancel(ctx), *fsist)
+
+				events.Publish(ctx, events.New(events.Claimed, req.SourceId, fsist))
+				return fromOwnedFS(fsist, req.SourceId), nil
+			}
+
+			if err := iac.Update(ctx, fsist.RunningScenario(fschall.Scenario), "", fschall, fsist); err != nil {
 				logger.Error(ctx, "updating pooled instance",
 					zap.Error(multierr.Combine(
 						clock.RUnlock(context.WithoutCancel(ctx)),
@@ -288,71 +355,22 @@ func (man *Manager) CreateInstance(ctx context.Context, req *CreateInstanceReque
 			return nil, errs.ErrInternalNoSub
 		}
 
+		events.Publish(ctx, events.New(events.Claimed, req.SourceId, fsist))
+
 		// Respond
-		var until *timestamppb.Timestamp
-		if fsist.Until != nil {
-			until = timestamppb.New(*fsist.Until)
-		}
-		return &Instance{
-			ChallengeId:    req.ChallengeId,
-			SourceId:       req.SourceId,
-			Since:          timestamppb.New(fsist.Since),
-			LastRenew:      timestamppb.New(fsist.LastRenew),
-			Until:          until,
-			ConnectionInfo: fsist.ConnectionInfo,
-			Flag: func() *string { // kept for retrocompatibility enough time for public migration
-				if len(fsist.Flags) == 1 {
-					return &fsist.Flags[0]
-				}
-				return nil
-			}(),
-			Flags:      fsist.Flags,
-			Additional: req.Additional,
-		}, nil
+		return fromOwnedFS(fsist, req.SourceId), nil
 	}
 
 	// Generate new identity
 	id := identity.New()
 	ctx = global.WithIdentity(ctx, id)
-	logger.Info(ctx, "creating new instance")
-
-	// No need to refine lock -> instance is unique per the identity.
-	// We MUST NOT release the clock until the instance is up & running,
-	// elseway the challenge could be deleted even if we are working on it.
-
-	// Spin up
-	stack, err := iac.NewStack(ctx, fschall, id)
-	if err != nil {
-		logger.Error(ctx, "building new stack",
-			zap.Error(multierr.Combine(
-				clock.RUnlock(context.WithoutCancel(ctx)),
-				err,
-			)),
-		)
-		return nil, errs.ErrInternalNoSub
-	}
-	if err := iac.Additional(ctx, stack, fschall.Additional, req.Additional); err != nil {
-		logger.Error(ctx, "configuring additionals on stack",
-			zap.Error(multierr.Combine(
-				clock.RUnlock(context.WithoutCancel(ctx)),
-				err,
-			)),
-		)
-		return nil, errs.ErrInternalNoSub
-	}
-
-	sr, err := stack.Up(ctx)
-	if err != nil {
-		err := &errs.ErrInternal{Sub: err}
-		logger.Error(ctx, "stack up",
-			zap.Error(multierr.Combine(
-				clock.RUnlock(context.WithoutCancel(ctx)),
-				err,
-			)),
-		)
-		return nil, errs.ErrInternalNoSub
-	}
+	logger.Info(ctx, "creating new instance",
+		zap.Bool("async", req.Async),
+	)
 
+	// Register the instance as provisioning and claim it before spinning it up.
+	// This avoids a source from creating multiple instances concurrently, and
+	// enables polling its state.
 	now := time.Now()
 	fsist := &fs.Instance{
 		Identity:    id,
@@ -361,18 +379,8 @@ func (man *Manager) CreateInstance(ctx context.Context, req *CreateInstanceReque
 		LastRenew:   now,
 		Until:       common.ComputeUntil(fschall.Until, fschall.Timeout),
 		Additional:  req.Additional,
+		Status:      fs.StatusProvisioning,
 	}
-	if err := stack.Export(ctx, sr, fsist); err != nil {
-		logger.Error(ctx, "extracting stack info",
-			zap.Error(multierr.Combine(
-				clock.RUnlock(context.WithoutCancel(ctx)),
-				err,
-			)),
-		)
-		return nil, errs.ErrInternalNoSub
-	}
-
-	// Save fsist
 	if err := fsist.Save(); err != nil {
 		logger.Error(ctx, "exporting instance information to filesystem",
 			zap.Error(multierr.Combine(
@@ -386,18 +394,73 @@ func (man *Manager) CreateInstance(ctx context.Context, req *CreateInstanceReque
 		logger.Error(ctx, "claiming instance",
 			zap.Error(multierr.Combine(
 				clock.RUnlock(context.WithoutCancel(ctx)),
+				fsist.Delete(),
 				err,
 			)),
 		)
 		return nil, errs.ErrInternalNoSub
 	}
-
-	logger.Info(ctx, "instance created successfully")
+	releaseQuota()
 	common.InstancesUDCounter().Add(ctx, 1,
 		metric.WithAttributeSet(common.InstanceAttrs(req.ChallengeId, req.SourceId, false)),
 	)
+	events.Publish(ctx, events.New(events.Created, req.SourceId, fsist))
+
+	// No need to refine lock -> instance is unique per the identity.
+	// We MUST NOT release the clock until the instance is up & running,
+	// elseway the challenge could be deleted even if we are working on it.
 
-	// Unlock RW instance
+	if req.Async {
+		go func(ctx context.Context, fsist fs.Instance) {
+			defer unlockChallenge(ctx, clock)
+
+			start := time.Now()
+			err := provision(ctx, fschall, &fsist, req.Additional)
+			if err == nil {
+				logger.Info(ctx, "instance created successfully",
+					zap.Object("outputs", fsist.Outputs),
+				)
+			}
+			_ = saveOutcome(ctx, &fsist, req.SourceId, err)
+			audit(ctx, fs.OpProvision, req.ChallengeId, req.SourceId, start, err)
+		}(context.WithoutCancel(ctx), *fsist)
+
+		// Respond
+		return fromOwnedFS(fsist, req.SourceId), nil
+	}
+
+	if err := provision(ctx, fschall, fsist, req.Additional); err != nil {
+		logger.Error(ctx, "spinning up instance", zap.Error(err))
+
+		// The caller is synchronously informed of the failure, so we don't keep
+		// track of the failed instance such that it can try again.
+		// Its operations logs are retained for the failure to be diagnosed.
+		if nerr := fsist.RetainOperationLogs(); nerr != nil {
+			logger.Error(ctx, "retaining failed instance operation logs", zap.Error(nerr))
+		}
+		if nerr := fsist.Delete(); nerr != nil {
+			logger.Error(ctx, "removing failed instance", zap.Error(nerr))
+		}
+		common.InstancesUDCounter().Add(ctx, -1,
+			metric.WithAttributeSet(common.InstanceAttrs(req.ChallengeId, req.SourceId, false)),
+		)
+		fsist.Status = fs.StatusFailed
+		fsist.Reason = err.Error()
+		events.Publish(ctx, events.New(events.Failed, req.SourceId, fsist))
+
+		unlockChallenge(ctx, clock)
+		return nil, errs.ErrInternalNoSub
+	}
+	if err := saveOutcome(ctx, fsist, req.SourceId, nil); err != nil {
+		unlockChallenge(ctx, clock)
+		return nil, errs.ErrInternalNoSub
+	}
+
+	logger.Info(ctx, "instance created successfully",
+		zap.Object("outputs", fsist.Outputs),
+	)
+
+	// Unlock R challenge
 	if err := clock.RUnlock(context.WithoutCancel(ctx)); err != nil {
 		logger.Error(ctx, "challenge R unlock",
 			zap.Error(err),
@@ -406,24 +469,133 @@ func (man *Manager) CreateInstance(ctx context.Context, req *CreateInstanceReque
 	}
 
 	// Respond
-	var until *timestamppb.Timestamp
-	if fsist.Until != nil {
-		until = timestamppb.New(*fsist.Until)
-	}
-	return &Instance{
-		ChallengeId:    req.ChallengeId,
-		SourceId:       req.SourceId,
-		Since:          timestamppb.New(fsist.Since),
-		LastRenew:      timestamppb.New(fsist.LastRenew),
-		Until:          until,
-		ConnectionInfo: fsist.ConnectionInfo,
-		Flag: func() *string { // kept for retrocompatibility enough time for public migration
-			if len(fsist.Flags) == 1 {
-				return &fsist.Flags[0]
-			}
-			return nil
-		}(),
-		Flags:      fsist.Flags,
-		Additional: req.Additional,
-	}, nil
+	return fromOwnedFS(fsist, req.SourceId), nil
+}
+
+// provision spins up the stack of a registered instance, and exports
+// its outputs in it.
+func provision(ctx context.Context, fschall *fs.Challenge, fsist *fs.Instance, additional map[string]string) error {
+	logger := global.Log()
+
+	// Track the operation, such that it can be canceled e.g. if the instance is deleted meanwhile
+	ctx, done := iac.Track(ctx, fsist.ChallengeID, fsist.Identity)
+	defer done()
+
+	stack, err := iac.NewStack(ctx, fschall, fsist.Identity)
+	if err != nil {
+		logger.Error(ctx, "building new stack", zap.Error(err))
+		return errors.Wrap(err, "building new stack")
+	}
+	if err := iac.Additional(ctx, stack, fschall.Additional, additional); err != nil {
+		logger.Error(ctx, "configuring additionals on stack", zap.Error(err))
+		return errors.Wrap(err, "configuring additionals on stack")
+	}
+
+	sourceID, _ := fs.LookupClaim(fsist.ChallengeID, fsist.Identity)
+	sr, err := upOrCleanup(ctx, stack, fsist.ChallengeID, sourceID, fsist)
+	if err != nil {
+		if cause := context.Cause(ctx); errors.Is(cause, iac.ErrOperationCanceled) {
+			logger.Info(ctx, "stack up canceled")
+			return cause
+		}
+		logger.Error(ctx, "stack up", zap.Error(err))
+		return errors.Wrap(err, "stack up")
+	}
+
+	if err := stack.Export(ctx, sr, fsist); err != nil {
+		logger.Error(ctx, "extracting stack info", zap.Error(err))
+		return errors.Wrap(err, "extracting stack info")
+	}
+
+	fsist.Scenario, fsist.Revision = fschall.Scenario, fschall.Revision
+
+	// The instance lifetime starts once it is up and running
+	now := time.Now()
+	fsist.Since = now
+	fsist.LastRenew = now
+	fsist.Until = common.ComputeUntil(fschall.Until, fschall.Timeout)
+	return nil
+}
+
+// upOrCleanup runs up on a new stack following the failure policy. If it still
+// fails, the resources it managed to create are destroyed, and the outcome of
+// this cleanup is recorded in the challenge journal.
+// The operations output is saved in the sink.
+func upOrCleanup(ctx context.Context, stack *iac.Stack, challID, sourceID string, sink fs.OperationLogSink) (*iac.Result, error) {
+	logger := global.Log()
+
+	sr, err := stack.UpWithRetries(ctx)
+	stack.SaveOutput(ctx, sink, err)
+	if err == nil {
+		return sr, nil
+	}
+
+	start := time.Now()
+	cerr := stack.Cleanup(context.WithoutCancel(ctx))
+	if cerr != nil {
+		logger.Error(ctx, "cleaning up failed stack, resources may leak", zap.Error(cerr))
+	} else {
+		logger.Info(ctx, "cleaned up failed stack")
+	}
+	stack.SaveOutput(ctx, sink, cerr)
+	audit(ctx, fs.OpCleanup, challID, sourceID, start, cerr)
+	return nil, err
+}
+
+// saveOutcome sets the instance status depending on the error of the operation
+// that ran on it, then saves it under its RW lock as it could be read concurrently.
+// Watchers of the instance are notified of the outcome.
+func saveOutcome(ctx context.Context, fsist *fs.Instance, sourceID string, opErr error) error {
+	logger := global.Log()
+
+	if opErr != nil {
+		fsist.Status = fs.StatusFailed
+		fsist.Reason = opErr.Error()
+	} else {
+		fsist.Status = fs.StatusReady
+		fsist.Reason = ""
+	}
+
+	ilock, err := common.LockInstance(ctx, fsist.ChallengeID, fsist.Identity)
+	if err != nil {
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "build instance lock", zap.Error(err))
+		return err
+	}
+	if err := ilock.RWLock(ctx); err != nil {
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "instance RW lock", zap.Error(err))
+		return err
+	}
+	defer func(lock lock.RWLock) {
+		if err := lock.RWUnlock(context.WithoutCancel(ctx)); err != nil {
+			err := &errs.ErrInternal{Sub: err}
+			logger.Error(ctx, "instance RW unlock", zap.Error(err))
+		}
+	}(ilock)
+
+	// The instance may have been deleted meanwhile, e.g. its provisioning was canceled
+	if err := fs.CheckInstance(fsist.ChallengeID, fsist.Identity); err != nil {
+		logger.Info(ctx, "instance deleted before its outcome could be saved")
+		return nil
+	}
+
+	if err := fsist.Save(); err != nil {
+		logger.Error(ctx, "exporting instance information to filesystem", zap.Error(err))
+		return err
+	}
+
+	typ := events.Ready
+	if opErr != nil {
+		typ = events.Failed
+	}
+	events.Publish(ctx, events.New(typ, sourceID, fsist))
+	return nil
+}
+
+func unlockChallenge(ctx context.Context, clock lock.RWLock) {
+	if err := clock.RUnlock(context.WithoutCancel(ctx)); err != nil {
+		err := &errs.ErrInternal{Sub: err}
+		global.Log().Error(ctx, "challenge R unlock", zap.Error(err))
+	}
 }
@@ -2,6 +2,7 @@ package instance
 
 import (
 	"context"
+	"time"
 
 	"go.opentelemetry.io/otel/metric"
 	"go.opentelemetry.io/otel/trace"
@@ -12,15 +13,19 @@ import (
 	"github.com/ctfer-io/chall-manager/api/v1/common"
 	"github.com/ctfer-io/chall-manager/global"
 	errs "github.com/ctfer-io/chall-manager/pkg/errors"
+	"github.com/ctfer-io/chall-manager/pkg/events"
 	"github.com/ctfer-io/chall-manager/pkg/fs"
 	"github.com/ctfer-io/chall-manager/pkg/iac"
 	"github.com/ctfer-io/chall-manager/pkg/lock"
 )
 
-func (man *Manager) DeleteInstance(ctx context.Context, req *DeleteInstanceRequest) (*emptypb.Empty, error) {
+func (man *Manager) DeleteInstance(ctx context.Context, req *DeleteInstanceRequest) (_ *emptypb.Empty, err error) {
 	logger := global.Log()
 	ctx = global.WithChallengeID(ctx, req.ChallengeId)
 	span := trace.SpanFromContext(ctx)
+	defer func(start time.Time) {
+		audit(ctx, fs.OpDelete, req.ChallengeId, req.SourceId, start, err)
+	}(time.Now())
 
 	// 1. Lock R TOTW
 	span.AddEvent("lock TOTW")
@@ -115,7 +120,7 @@ func (man *Manager) DeleteInstance(ctx context.Context, req *DeleteInstanceReque
 
 	// 5. Lock RW instance
 	ctx = global.WithSourceID(ctx, req.SourceId)
-	id, err := fs.FindInstance(req.ChallengeId, req.SourceId)
+	id, owner, err := fs.ResolveInstance(req.ChallengeId, req.SourceId)
 	if err != nil {
 		err := &errs.ErrInternal{Sub: err}
 		logger.Error(ctx, "finding instance",
@@ -126,6 +131,16 @@ func (man *Manager) DeleteInstance(ctx context.Context, req *DeleteInstanceReque
 		)
 		return nil, errs.ErrInternalNoSub
 	}
+	if owner != req.SourceId {
+		// Shared instances are deleted only once the group releases it
+		if err := clock.RUnlock(context.WithoutCancel(ctx)); err != nil {
+			logger.Error(ctx, "unlocking R challenge", zap.Error(err))
+		}
+		return nil, &errs.ErrNotOwner{
+			ChallengeID: req.ChallengeId,
+			SourceID:    req.SourceId,
+		}
+	}
 
 	ctx = global.WithIdentity(ctx, id)
 	ilock, err := common.LockInstance(ctx, req.ChallengeId, id)
@@ -213,9 +228,48 @@ func (man *Manager) DeleteInstance(ctx context.Context, req *DeleteInstanceReque
 		return nil, err
 	}
 
+	// Don't delete an instance while it is being spun up or down
+	switch fsist.Status {
+	case fs.StatusProvisioning:
+		// Cancel the provisioning if it runs in this replica, it then cleans up after itself.
+		// Wait for this cleanup to be over even if the caller went away, such that
+		// it does not run concurrently with the destruction below.
+		canceled, err := iac.Cancel(context.WithoutCancel(ctx), req.ChallengeId, id)
+		if err != nil {
+			err := &errs.ErrInternal{Sub: err}
+			logger.Error(ctx, "canceling instance provisioning", zap.Error(err))
+			return nil, errs.ErrInternalNoSub
+		}
+		if !canceled {
+			return nil, &errs.ErrNoOperation{
+				ChallengeID: req.ChallengeId,
+				Identity:    id,
+			}
+		}
+		logger.Info(ctx, "canceled instance provisioning")
+	case fs.StatusDeleting:
+		return nil, &errs.ErrInstanceStatus{
+			ChallengeID: req.ChallengeId,
+			Identity:    id,
+			Operation:   "deleted",
+			Status:      string(fsist.Status),
+		}
+	}
+	status := fsist.Status
+	fsist.Status = fs.StatusDeleting
+	if err := fsist.Save(); err != nil {
+		logger.Error(ctx, "exporting instance information to filesystem",
+			zap.Error(err),
+		)
+		return nil, errs.ErrInternalNoSub
+	}
+
 	// Reload cache if necessary
-	stack, err := iac.LoadStack(ctx, fschall.Scenario, id)
+	stack, err := iac.LoadStack(ctx, fsist.RunningScenario(fschall.Scenario), id)
 	if err != nil {
+		if rerr := restoreStatus(fsist, status); rerr != nil {
+			logger.Error(ctx, "restoring instance status", zap.Error(rerr))
+		}
 		if err, ok := err.(*errs.ErrInternal); ok {
 			logger.Error(ctx, "creating challenge instance stack",
 				zap.Error(err),
@@ -224,33 +278,53 @@ func (man *Manager) DeleteInstance(ctx context.Context, req *DeleteInstanceReque
 		}
 		return nil, err
 	}
-	if err := stack.Import(ctx, fsist); err != nil {
-		err := &errs.ErrInternal{Sub: err}
-		logger.Error(ctx, "unmarshalling Pulumi state",
-			zap.Error(err),
-		)
-		return nil, errs.ErrInternalNoSub
+	// A failed instance may have no state to import, if it failed before being exported
+	if fsist.State != nil {
+		if err := stack.Import(ctx, fsist); err != nil {
+			err := &errs.ErrInternal{Sub: err}
+			logger.Error(ctx, "unmarshalling Pulumi state",
+				zap.Error(multierr.Combine(
+					restoreStatus(fsist, status),
+					err,
+				)),
+			)
+			return nil, errs.ErrInternalNoSub
+		}
 	}
 
 	logger.Info(ctx, "deleting instance")
 
 	if err := stack.Down(ctx); err != nil {
+		stack.SaveOutput(ctx, fsist, err)
 		err := &errs.ErrInternal{Sub: err}
 		logger.Error(ctx, "stack down",
-			zap.Error(err),
+			zap.Error(multierr.Combine(
+				restoreStatus(fsist, status),
+				err,
+			)),
 		)
 		return nil, errs.ErrInternalNoSub
 	}
 
+	// Keep track of the flags for leak detection, best effort
+	if err := fsist.ArchiveFlags(req.SourceId); err != nil {
+		logger.Error(ctx, "archiving instance flags", zap.Error(err))
+	}
+
 	if err := fsist.Delete(); err != nil {
+		// Resources are destroyed, restoring the status enables retrying the deletion
 		err := &errs.ErrInternal{Sub: err}
 		logger.Error(ctx, "removing instance directory",
-			zap.Error(err),
+			zap.Error(multierr.Combine(
+				restoreStatus(fsist, status),
+				err,
+			)),
 		)
 		return nil, errs.ErrInternalNoSub
 	}
 
 	logger.Info(ctx, "deleted instance successfully")
+	events.Publish(ctx, events.New(events.Deleted, req.SourceId, fsist))
 	common.InstancesUDCounter().Add(ctx, -1,
 		metric.WithAttributeSet(common.InstanceAttrs(req.ChallengeId, req.SourceId, false)),
 	)
@@ -270,3 +344,9 @@ func (man *Manager) DeleteInstance(ctx context.Context, req *DeleteInstanceReque
 
 	return nil, nil
 }
+
+// restoreStatus saves back the status of an instance that could not be deleted.
+func restoreStatus(fsist *fs.Instance, status fs.InstanceStatus) error {
+	fsist.Status = status
+	return fsist.Save()
+}
@@ -0,0 +1,118 @@
+package instance
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+
+	"github.com/ctfer-io/chall-manager/api/v1/common"
+	"github.com/ctfer-io/chall-manager/global"
+	errs "github.com/ctfer-io/chall-manager/pkg/errors"
+	"github.com/ctfer-io/chall-manager/pkg/fs"
+	"github.com/ctfer-io/chall-manager/pkg/lock"
+)
+
+func (man *Manager) ListInstanceEvents(req *ListInstanceEventsRequest, server InstanceManager_ListInstanceEventsServer) error {
+	logger := global.Log()
+	ctx := global.WithChallengeID(server.Context(), req.ChallengeId)
+	span := trace.SpanFromContext(ctx)
+
+	// 1. Lock R TOTW
+	span.AddEvent("lock TOTW")
+	totw, err := common.LockTOTW(ctx)
+	if err != nil {
+		if totw.IsCanceled(err) {
+			return nil
+		}
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "build TOTW lock", zap.Error(err))
+		return errs.ErrInternalNoSub
+	}
+	if err := totw.RLock(ctx); err != nil {
+		if totw.IsCanceled(err) {
+			return nil
+		}
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "TOTW R lock", zap.Error(err))
+		return errs.ErrInternalNoSub
+	}
+	span.AddEvent("locked TOTW")
+
+	// 2. Lock R challenge
+	clock, err := common.LockChallenge(ctx, req.ChallengeId)
+	if err != nil {
+		if clock.IsCanceled(err) {
+			// If canceled, we need to recover
+			if err := totw.RUnlock(context.WithoutCancel(ctx)); err != nil {
+				err := &errs.ErrInternal{Sub: err}
+				logger.Error(ctx, "recovering from build challenge lock", zap.Error(err))
+				return errs.ErrInternalNoSub
+			}
+			return nil // recovery is successful, we can quit safely
+		}
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "build challenge lock", zap.Error(multierr.Combine(
+			totw.RUnlock(context.WithoutCancel(ctx)),
+			err,
+		)))
+		return errs.ErrInternalNoSub
+	}
+	if err := clock.RLock(ctx); err != nil {
+		if clock.IsCanceled(err) {
+			// If canceled, we need to recover
+			if err := totw.RUnlock(context.WithoutCancel(ctx)); err != nil {
+				err := &errs.ErrInternal{Sub: err}
+				logger.Error(ctx, "recovering from challenge R lock", zap.Error(err))
+				return errs.ErrInternalNoSub
+			}
+			return nil // recovery is successful, we can quit safely
+		}
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "challenge R lock", zap.Error(multierr.Combine(
+			totw.RUnlock(context.WithoutCancel(ctx)),
+			err,
+		)))
+		return errs.ErrInternalNoSub
+	}
+	defer func(lock lock.RWLock) {
+		if err := lock.RUnlock(context.WithoutCancel(ctx)); err != nil {
+			err := &errs.ErrInternal{Sub: err}
+			logger.Error(ctx, "challenge R unlock", zap.Error(err))
+		}
+	}(clock)
+
+	// 3. Unlock R TOTW
+	if err := totw.RUnlock(context.WithoutCancel(ctx)); err != nil {
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "TOTW R unlock", zap.Error(err))
+		return errs.ErrInternalNoSub
+	}
+	span.AddEvent("unlocked TOTW")
+
+	// 4. If challenge does not exist, return error
+	if err := fs.CheckChallenge(req.ChallengeId); err != nil {
+		return err
+	}
+
+	// 5. Read the journal entries in the time range
+	entries, err := fs.ReadJournal(req.ChallengeId, toTime(req.From), toTime(req.To))
+	if err != nil {
+		logger.Error(ctx, "reading challenge journal", zap.Error(err))
+		return errs.ErrInternalNoSub
+	}
+	for _, entry := range entries {
+		if req.SourceId != nil && entry.SourceID != *req.SourceId {
+			continue
+		}
+		if err := server.Send(fromJournalEntry(entry)); err != nil {
+			return err
+		}
+	}
+
+	// 6. Unlock R challenge
+	//    -> defered after 2 (fault-tolerance)
+
+	return nil
+}
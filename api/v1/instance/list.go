@@ -0,0 +1,26 @@
+package instance
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/ctfer-io/chall-manager/api/v1/common"
+)
+
+func (man *Manager) ListInstances(ctx context.Context, req *QueryInstanceRequest) (*ListInstancesResponse, error) {
+	// Run the query, collecting what it streams
+	col := common.NewCollector[Instance](ctx)
+	if err := man.QueryInstance(req, col); err != nil {
+		return nil, err
+	}
+
+	res := &ListInstancesResponse{
+		Instances: col.Items(),
+	}
+	res.NextPageToken, _ = col.Header("next-page-token")
+	if v, ok := col.Header("remaining-instances"); ok {
+		remaining, _ := strconv.ParseInt(v, 10, 64) // always formatted as such
+		res.RemainingInstances = &remaining
+	}
+	return res, nil
+}
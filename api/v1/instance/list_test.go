@@ -0,0 +1,80 @@
+package instance
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ctfer-io/chall-manager/global"
+	"github.com/ctfer-io/chall-manager/pkg/fs"
+)
+
+func Test_U_ListInstances(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	global.Conf.Directory = t.TempDir()
+	require.NoError(os.Mkdir(filepath.Join(global.Conf.Directory, "chall"), os.ModePerm))
+	global.Conf.MaxInstancesPerSource = 5
+	t.Cleanup(func() { global.Conf.MaxInstancesPerSource = 0 })
+
+	// The source claimed one instance of each challenge, along pooled ones
+	expected := []string{}
+	for _, challID := range []string{"chall-b", "chall-a", "chall-c"} {
+		require.NoError((&fs.Challenge{ID: challID}).Save())
+		for _, identity := range []string{"identity-2", "identity-1"} {
+			fsist := &fs.Instance{
+				ChallengeID:    challID,
+				Identity:       identity,
+				ConnectionInfo: challID + "/" + identity,
+			}
+			require.NoError(fsist.Save())
+		}
+		claimed := &fs.Instance{ChallengeID: challID, Identity: "identity-1"}
+		require.NoError(claimed.Claim("player"))
+	}
+	for _, challID := range []string{"chall-a", "chall-b", "chall-c"} {
+		for _, identity := range []string{"identity-1", "identity-2"} {
+			expected = append(expected, challID+"/"+identity)
+		}
+	}
+
+	man := &Manager{}
+	ctx := context.Background()
+
+	// Walk through all pages, ordered by challenge then identity
+	got := []string{}
+	token := ""
+	for {
+		res, err := man.ListInstances(ctx, &QueryInstanceRequest{
+			PageSize:  4,
+			PageToken: token,
+		})
+		require.NoError(err)
+		assert.LessOrEqual(len(res.Instances), 4)
+		assert.Nil(res.RemainingInstances)
+
+		for _, ist := range res.Instances {
+			got = append(got, ist.ConnectionInfo)
+		}
+		if res.NextPageToken == "" {
+			break
+		}
+		token = res.NextPageToken
+	}
+	assert.Equal(expected, got)
+
+	// Without page size, all instances of the source are returned at once
+	res, err := man.ListInstances(ctx, &QueryInstanceRequest{
+		SourceId: "player",
+	})
+	require.NoError(err)
+	assert.Len(res.Instances, 3)
+	assert.Empty(res.NextPageToken)
+	require.NotNil(res.RemainingInstances)
+	assert.Equal(int64(2), *res.RemainingInstances)
+}
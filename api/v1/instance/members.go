@@ -0,0 +1,240 @@
+package instance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+
+	"github.com/ctfer-io/chall-manager/api/v1/common"
+	"github.com/ctfer-io/chall-manager/global"
+	errs "github.com/ctfer-io/chall-manager/pkg/errors"
+	"github.com/ctfer-io/chall-manager/pkg/fs"
+	"github.com/ctfer-io/chall-manager/pkg/lock"
+)
+
+func (man *Manager) AddInstanceMember(ctx context.Context, req *AddInstanceMemberRequest) (*Instance, error) {
+	return updateMembers(ctx, req.ChallengeId, req.SourceId, req.MemberId, true)
+}
+
+func (man *Manager) RemoveInstanceMember(ctx context.Context, req *RemoveInstanceMemberRequest) (*Instance, error) {
+	return updateMembers(ctx, req.ChallengeId, req.SourceId, req.MemberId, false)
+}
+
+// updateMembers adds or removes a member of the group that owns the instance.
+// The challenge is RW locked such that no concurrent creation or membership
+// update could make a source resolve to multiple instances.
+func updateMembers(ctx context.Context, challengeID, sourceID, memberID string, add bool) (*Instance, error) {
+	logger := global.Log()
+	ctx = global.WithChallengeID(ctx, challengeID)
+	ctx = global.WithSourceID(ctx, sourceID)
+	span := trace.SpanFromContext(ctx)
+
+	if memberID == "" || memberID == sourceID {
+		return nil, errors.New("member must be set and differ from the group")
+	}
+
+	// 1. Lock R TOTW
+	span.AddEvent("lock TOTW")
+	totw, err := common.LockTOTW(ctx)
+	if err != nil {
+		if totw.IsCanceled(err) {
+			return nil, nil
+		}
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "build TOTW lock", zap.Error(err))
+		return nil, errs.ErrInternalNoSub
+	}
+	if err := totw.RLock(ctx); err != nil {
+		if totw.IsCanceled(err) {
+			return nil, nil
+		}
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "TOTW R lock", zap.Error(err))
+		return nil, errs.ErrInternalNoSub
+	}
+	span.AddEvent("locked TOTW")
+
+	// 2. Lock RW challenge
+	clock, err := common.LockChallenge(ctx, challengeID)
+	if err != nil {
+		if clock.IsCanceled(err) {
+			// If canceled, we need to recover
+			if err := totw.RUnlock(context.WithoutCancel(ctx)); err != nil {
+				err := &errs.ErrInternal{Sub: err}
+				logger.Error(ctx, "recovering from build challenge lock", zap.Error(err))
+				return nil, errs.ErrInternalNoSub
+			}
+			return nil, nil // recovery is successful, we can quit safely
+		}
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "build challenge lock", zap.Error(multierr.Combine(
+			totw.RUnlock(context.WithoutCancel(ctx)),
+			err,
+		)))
+		return nil, errs.ErrInternalNoSub
+	}
+	if err := clock.RWLock(ctx); err != nil {
+		if clock.IsCanceled(err) {
+			// If canceled, we need to recover
+			if err := totw.RUnlock(context.WithoutCancel(ctx)); err != nil {
+				err := &errs.ErrInternal{Sub: err}
+				logger.Error(ctx, "recovering from challenge RW lock", zap.Error(err))
+				return nil, errs.ErrInternalNoSub
+			}
+			return nil, nil // recovery is successful, we can quit safely
+		}
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "challenge RW lock", zap.Error(multierr.Combine(
+			totw.RUnlock(context.WithoutCancel(ctx)),
+			err,
+		)))
+		return nil, errs.ErrInternalNoSub
+	}
+	defer func(lock lock.RWLock) {
+		if err := lock.RWUnlock(context.WithoutCancel(ctx)); err != nil {
+			err := &errs.ErrInternal{Sub: err}
+			logger.Error(ctx, "challenge RW unlock", zap.Error(err))
+		}
+	}(clock)
+
+	// 3. Unlock R TOTW
+	if err := totw.RUnlock(context.WithoutCancel(ctx)); err != nil {
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "TOTW R unlock", zap.Error(err))
+		return nil, errs.ErrInternalNoSub
+	}
+	span.AddEvent("unlocked TOTW")
+
+	// 4. If challenge is not shared, or the group does not own an instance, return error
+	fschall, err := fs.LoadChallenge(challengeID)
+	if err != nil {
+		if err, ok := err.(*errs.ErrInternal); ok {
+			logger.Error(ctx, "loading challenge",
+				zap.Error(err),
+			)
+			return nil, errs.ErrInternalNoSub
+		}
+		return nil, err
+	}
+	if add && !fschall.Shared {
+		return nil, fmt.Errorf("challenge %s instances are not shared", challengeID)
+	}
+	id, owner, err := fs.ResolveInstance(challengeID, sourceID)
+	if err != nil {
+		if _, ok := err.(*errs.ErrInstanceExist); ok {
+			return nil, err
+		}
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "finding instance",
+			zap.Error(err),
+		)
+		return nil, errs.ErrInternalNoSub
+	}
+	if owner != sourceID {
+		return nil, &errs.ErrNotOwner{
+			ChallengeID: challengeID,
+			SourceID:    sourceID,
+		}
+	}
+	if add {
+		// A source resolves to at most one instance per challenge
+		if _, err := fs.FindInstance(challengeID, memberID); err == nil {
+			return nil, &errs.ErrInstanceExist{
+				ChallengeID: challengeID,
+				SourceID:    memberID,
+				Exist:       true,
+			}
+		}
+		// Being a member counts in the source quota
+		releaseQuota, err := lockQuota(ctx, fschall, memberID)
+		if err != nil {
+			if _, ok := err.(*errs.ErrInternal); ok {
+				logger.Error(ctx, "checking member quota", zap.Error(err))
+				return nil, errs.ErrInternalNoSub
+			}
+			return nil, err
+		}
+		defer releaseQuota()
+	}
+
+	// 5. Lock RW instance
+	ctx = global.WithIdentity(ctx, id)
+	ilock, err := common.LockInstance(ctx, challengeID, id)
+	if err != nil {
+		if ilock.IsCanceled(err) {
+			return nil, nil
+		}
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "build instance lock", zap.Error(err))
+		return nil, errs.ErrInternalNoSub
+	}
+	if err := ilock.RWLock(ctx); err != nil {
+		if ilock.IsCanceled(err) {
+			return nil, nil
+		}
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "challenge instance RW lock", zap.Error(err))
+		return nil, errs.ErrInternalNoSub
+	}
+	defer func(lock lock.RWLock) {
+		if err := lock.RWUnlock(context.WithoutCancel(ctx)); err != nil {
+			err := &errs.ErrInternal{Sub: err}
+			logger.Error(ctx, "instance RW unlock", zap.Error(err))
+		}
+	}(ilock)
+
+	// 6. Update the members
+	fsist, err := fs.LoadInstance(challengeID, id)
+	if err != nil {
+		if err, ok := err.(*errs.ErrInternal); ok {
+			logger.Error(ctx, "loading challenge instance",
+				zap.Error(err),
+			)
+			return nil, errs.ErrInternalNoSub
+		}
+		return nil, err
+	}
+	if fsist.Status == fs.StatusDeleting {
+		return nil, &errs.ErrInstanceStatus{
+			ChallengeID: challengeID,
+			Identity:    id,
+			Operation:   "updated",
+			Status:      string(fsist.Status),
+		}
+	}
+	if add {
+		err = fsist.AddMember(memberID)
+	} else {
+		if !slices.Contains(fsist.Members, memberID) {
+			return nil, &errs.ErrInstanceExist{
+				ChallengeID: challengeID,
+				SourceID:    memberID,
+				Exist:       false,
+			}
+		}
+		err = fsist.RemoveMember(memberID)
+	}
+	if err != nil {
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "saving instance members",
+			zap.Error(err),
+		)
+		return nil, errs.ErrInternalNoSub
+	}
+	logger.Info(ctx, "instance members updated",
+		zap.String("member", memberID),
+		zap.Bool("added", add),
+	)
+
+	// 7. Unlock RW instance
+	//    -> defered after 5 (fault-tolerance)
+	// 8. Unlock RW challenge
+	//    -> defered after 2 (fault-tolerance)
+
+	return fromOwnedFS(fsist, owner), nil
+}
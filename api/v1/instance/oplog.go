@@ -0,0 +1,204 @@
+package instance
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/ctfer-io/chall-manager/api/v1/common"
+	"github.com/ctfer-io/chall-manager/global"
+	errs "github.com/ctfer-io/chall-manager/pkg/errors"
+	"github.com/ctfer-io/chall-manager/pkg/fs"
+	"github.com/ctfer-io/chall-manager/pkg/lock"
+)
+
+func (man *Manager) GetInstanceOperationLog(ctx context.Context, req *GetInstanceOperationLogRequest) (*GetInstanceOperationLogResponse, error) {
+	logger := global.Log()
+	ctx = global.WithChallengeID(ctx, req.ChallengeId)
+	ctx = global.WithSourceID(ctx, req.SourceId)
+	span := trace.SpanFromContext(ctx)
+
+	// 1. Lock R TOTW
+	span.AddEvent("lock TOTW")
+	totw, err := common.LockTOTW(ctx)
+	if err != nil {
+		if totw.IsCanceled(err) {
+			return nil, nil
+		}
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "build TOTW lock", zap.Error(err))
+		return nil, errs.ErrInternalNoSub
+	}
+	if err := totw.RLock(ctx); err != nil {
+		if totw.IsCanceled(err) {
+			return nil, nil
+		}
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "TOTW R lock", zap.Error(err))
+		return nil, errs.ErrInternalNoSub
+	}
+	span.AddEvent("locked TOTW")
+
+	// 2. Lock R challenge
+	clock, err := common.LockChallenge(ctx, req.ChallengeId)
+	if err != nil {
+		if clock.IsCanceled(err) {
+			// If canceled, we need to recover
+			if err := totw.RUnlock(context.WithoutCancel(ctx)); err != nil {
+				err := &errs.ErrInternal{Sub: err}
+				logger.Error(ctx, "recovering from build challenge lock", zap.Error(err))
+				return nil, errs.ErrInternalNoSub
+			}
+			return nil, nil // recovery is successful, we can quit safely
+		}
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "build challenge lock", zap.Error(multierr.Combine(
+			totw.RUnlock(context.WithoutCancel(ctx)),
+			err,
+		)))
+		return nil, errs.ErrInternalNoSub
+	}
+	if err := clock.RLock(ctx); err != nil {
+		if clock.IsCanceled(err) {
+			// If canceled, we need to recover
+			if err := totw.RUnlock(context.WithoutCancel(ctx)); err != nil {
+				err := &errs.ErrInternal{Sub: err}
+				logger.Error(ctx, "recovering from challenge R lock", zap.Error(err))
+				return nil, errs.ErrInternalNoSub
+			}
+			return nil, nil // recovery is successful, we can quit safely
+		}
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "challenge R lock", zap.Error(multierr.Combine(
+			totw.RUnlock(context.WithoutCancel(ctx)),
+			err,
+		)))
+		return nil, errs.ErrInternalNoSub
+	}
+	defer func(lock lock.RWLock) {
+		if err := lock.RUnlock(context.WithoutCancel(ctx)); err != nil {
+			err := &errs.ErrInternal{Sub: err}
+			logger.Error(ctx, "challenge R unlock", zap.Error(err))
+		}
+	}(clock)
+
+	// 3. Unlock R TOTW
+	if err := totw.RUnlock(context.WithoutCancel(ctx)); err != nil {
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "TOTW R unlock", zap.Error(err))
+		return nil, errs.ErrInternalNoSub
+	}
+	span.AddEvent("unlocked TOTW")
+
+	// 4. If challenge/instance does not exist, return error
+	if err := fs.CheckChallenge(req.ChallengeId); err != nil {
+		return nil, err
+	}
+	id, err := fs.FindInstance(req.ChallengeId, req.SourceId)
+	if err != nil {
+		if _, ok := err.(*errs.ErrInstanceExist); ok {
+			// The instance may have failed to be created, then its logs were retained
+			logs, rerr := retainedLogs(req.ChallengeId, req.SourceId)
+			if rerr != nil {
+				logger.Error(ctx, "loading retained operation logs", zap.Error(rerr))
+				return nil, errs.ErrInternalNoSub
+			}
+			if len(logs) == 0 {
+				return nil, err
+			}
+			return toOperationLogs(logs, req.Operation), nil
+		}
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "finding instance",
+			zap.Error(err),
+		)
+		return nil, errs.ErrInternalNoSub
+	}
+
+	// 5. Lock R instance
+	ctx = global.WithIdentity(ctx, id)
+	ilock, err := common.LockInstance(ctx, req.ChallengeId, id)
+	if err != nil {
+		if ilock.IsCanceled(err) {
+			return nil, nil
+		}
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "build instance lock", zap.Error(err))
+		return nil, errs.ErrInternalNoSub
+	}
+	if err := ilock.RLock(ctx); err != nil {
+		if ilock.IsCanceled(err) {
+			return nil, nil
+		}
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "challenge instance R lock", zap.Error(err))
+		return nil, errs.ErrInternalNoSub
+	}
+	defer func(lock lock.RWLock) {
+		if err := lock.RUnlock(context.WithoutCancel(ctx)); err != nil {
+			err := &errs.ErrInternal{Sub: err}
+			logger.Error(ctx, "instance R unlock", zap.Error(err))
+		}
+	}(ilock)
+
+	// 6. Load the operation logs
+	logs, err := fs.LoadOperationLogs(req.ChallengeId, id)
+	if err != nil {
+		logger.Error(ctx, "loading operation logs", zap.Error(err))
+		return nil, errs.ErrInternalNoSub
+	}
+	res := toOperationLogs(logs, req.Operation)
+
+	// 7. Unlock R instance
+	//    -> defered after 5 (fault-tolerance)
+	// 8. Unlock R challenge
+	//    -> defered after 2 (fault-tolerance)
+
+	return res, nil
+}
+
+// retainedLogs returns the operation logs retained for the last instance the source
+// failed to create, as referenced by the challenge journal.
+func retainedLogs(challID, sourceID string) ([]*fs.OperationLog, error) {
+	entries, err := fs.ReadJournal(challID, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		if entry.SourceID != sourceID || entry.Operation != fs.OpCreate || entry.Identity == "" {
+			continue
+		}
+		if entry.Outcome != fs.OutcomeFailure {
+			return nil, nil
+		}
+		return fs.LoadRetainedOperationLogs(challID, entry.Identity)
+	}
+	return nil, nil
+}
+
+func toOperationLogs(logs []*fs.OperationLog, operation *string) *GetInstanceOperationLogResponse {
+	res := &GetInstanceOperationLogResponse{
+		Logs: make([]*OperationLog, 0, len(logs)),
+	}
+	for _, log := range logs {
+		if operation != nil && log.Operation != *operation {
+			continue
+		}
+		var e *string
+		if log.Error != "" {
+			e = &log.Error
+		}
+		res.Logs = append(res.Logs, &OperationLog{
+			Operation: log.Operation,
+			At:        timestamppb.New(log.At),
+			Error:     e,
+			Output:    log.Output,
+			Truncated: log.Truncated,
+		})
+	}
+	return res
+}
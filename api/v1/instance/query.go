@@ -2,18 +2,25 @@ package instance
 
 import (
 	"context"
-	sync "sync"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
-	"github.com/ctfer-io/chall-manager/api/v1/common"
-	"github.com/ctfer-io/chall-manager/global"
-	errs "github.com/ctfer-io/chall-manager/pkg/errors"
-	"github.com/ctfer-io/chall-manager/pkg/fs"
-	"github.com/ctfer-io/chall-manager/pkg/lock"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/multierr"
 	"go.uber.org/zap"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/ctfer-io/chall-manager/api/v1/common"
+	"github.com/ctfer-io/chall-manager/global"
+	errs "github.com/ctfer-io/chall-manager/pkg/errors"
+	"github.com/ctfer-io/chall-manager/pkg/fs"
+	"github.com/ctfer-io/chall-manager/pkg/labels"
+	"github.com/ctfer-io/chall-manager/pkg/lock"
 )
 
 func (man *Manager) QueryInstance(req *QueryInstanceRequest, server InstanceManager_QueryInstanceServer) error {
@@ -21,6 +28,23 @@ func (man *Manager) QueryInstance(req *QueryInstanceRequest, server InstanceMana
 	ctx := server.Context()
 	span := trace.SpanFromContext(ctx)
 
+	// 0. Validate request
+	if req.PageSize < 0 {
+		return common.ErrInvalidPageSize
+	}
+	if req.SourceId != "" && len(req.SourceIds) != 0 {
+		return errors.New("source_id and source_ids are mutually exclusive")
+	}
+	filter, err := toFilter(req)
+	if err != nil {
+		return err
+	}
+	last, err := common.DecodePageToken(req.PageToken)
+	if err != nil {
+		return err
+	}
+	lastChall, lastIst, _ := strings.Cut(last, keySep)
+
 	// 1. Lock RW TOTW -> R should be sufficient, but we want this query to be as fast as possible
 	span.AddEvent("lock TOTW")
 	totw, err := common.LockTOTW(ctx)
@@ -42,8 +66,8 @@ func (man *Manager) QueryInstance(req *QueryInstanceRequest, server InstanceMana
 	}
 	span.AddEvent("locked TOTW")
 
-	// 2. Fetch all challenges
-	fschalls, err := fs.ListChallenges()
+	// 2. Fetch challenges that match the filter, from the one of the page
+	fschalls, err := fs.FilterChallenges(filter)
 	if err != nil {
 		err := &errs.ErrInternal{Sub: err}
 		logger.Error(ctx, "listing challenges", zap.Error(multierr.Combine(
@@ -52,97 +76,77 @@ func (man *Manager) QueryInstance(req *QueryInstanceRequest, server InstanceMana
 		)))
 		return errs.ErrInternalNoSub
 	}
+	fschalls = fschalls[sort.SearchStrings(fschalls, lastChall):]
 
-	// 3. Create "relock" and "work" wait groups for all challenges, and for each
-	qs := common.NewQueryServer[*Instance](server)
-	relock := &sync.WaitGroup{}
-	relock.Add(len(fschalls))
-	work := &sync.WaitGroup{}
-	work.Add(len(fschalls))
-	cerr := make(chan error, len(fschalls))
-	for _, challengeID := range fschalls {
-		go func(relock, work *sync.WaitGroup, cerr chan<- error, challengeID string) {
-			// Track span of loading stack
-			ctx, span := global.Tracer.Start(ctx, "reading-challenge", trace.WithAttributes(
-				attribute.String("challenge_id", challengeID),
-			))
-			defer span.End()
-
-			ctx = global.WithChallengeID(ctx, challengeID)
-
-			// 4.e. Done in the "work" wait group
-			defer work.Done()
-
-			// 4.a. Lock R challenge
-			clock, err := common.LockChallenge(ctx, challengeID)
-			if err != nil {
-				if clock.IsCanceled(err) {
-					err = nil
-				}
-				cerr <- err
-				relock.Done() // release to avoid dead-lock
-				return
-			}
-			if err := clock.RLock(ctx); err != nil {
-				if clock.IsCanceled(err) {
-					err = nil
-				}
-				cerr <- err
-				relock.Done() // release to avoid dead-lock
-				return
+	// Expose how many instances the source can still create, if limited.
+	// The quota is the one of the challenge if a single one is queried, elseway
+	// the server-wide one. Headers must be sent before any instance.
+	if req.SourceId != "" {
+		fschall := &fs.Challenge{} // no override, i.e. the server-wide quota
+		if len(req.ChallengeIds) == 1 {
+			if c, err := fs.LoadChallenge(req.ChallengeIds[0]); err == nil {
+				fschall = c
 			}
-			defer func(lock lock.RWLock) {
-				if err := lock.RUnlock(context.WithoutCancel(ctx)); err != nil {
-					err := &errs.ErrInternal{Sub: err}
-					logger.Error(ctx, "challenge R unlock", zap.Error(err))
-				}
-			}(clock)
-
-			// 4.b. Done in the "relock wait group"
-			relock.Done()
-
-			// 4.d. Fetch challenge instance for this source
-			ist, err := fs.FindInstance(challengeID, req.SourceId)
-			if err != nil {
-				cerr <- err
-				return
+		}
+		quota, remaining, err := remainingQuota(fschall, req.SourceId)
+		if err != nil {
+			err := &errs.ErrInternal{Sub: err}
+			logger.Error(ctx, "counting source instances", zap.Error(multierr.Combine(
+				err,
+				totw.RWUnlock(context.WithoutCancel(ctx)),
+			)))
+			return errs.ErrInternalNoSub
+		}
+		if quota != 0 {
+			md := metadata.Pairs("remaining-instances", strconv.FormatInt(remaining, 10))
+			if err := server.SetHeader(md); err != nil {
+				err := &errs.ErrInternal{Sub: err}
+				logger.Error(ctx, "setting remaining instances header", zap.Error(multierr.Combine(
+					err,
+					totw.RWUnlock(context.WithoutCancel(ctx)),
+				)))
+				return errs.ErrInternalNoSub
 			}
+		}
+	}
 
-			fsist, err := fs.LoadInstance(challengeID, ist)
-			if err != nil {
-				cerr <- err
-				return
+	// 3. Lock R all challenges, in order, then unlock RW TOTW
+	clocks := make([]lock.RWLock, 0, len(fschalls))
+	unlock := func(clock lock.RWLock) {
+		if err := clock.RUnlock(context.WithoutCancel(ctx)); err != nil {
+			err := &errs.ErrInternal{Sub: err}
+			logger.Error(ctx, "challenge R unlock", zap.Error(err))
+		}
+	}
+	defer func() {
+		// Unlock the challenges that have not been walked through
+		for _, clock := range clocks {
+			if clock != nil {
+				unlock(clock)
 			}
-
-			var until *timestamppb.Timestamp
-			if fsist.Until != nil {
-				until = timestamppb.New(*fsist.Until)
+		}
+	}()
+	for _, challengeID := range fschalls {
+		clock, err := common.LockChallenge(ctx, challengeID)
+		if err == nil {
+			err = clock.RLock(ctx)
+		}
+		if err != nil {
+			if clock.IsCanceled(err) {
+				err = nil
+			} else {
+				err = &errs.ErrInternal{Sub: err}
+				logger.Error(ctx, "challenge R lock", zap.Error(err))
+				err = errs.ErrInternalNoSub
 			}
-
-			if err := qs.SendMsg(&Instance{
-				ChallengeId:    challengeID,
-				SourceId:       req.SourceId,
-				Since:          timestamppb.New(fsist.Since),
-				LastRenew:      timestamppb.New(fsist.LastRenew),
-				Until:          until,
-				ConnectionInfo: fsist.ConnectionInfo,
-				Flag: func() *string { // kept for retrocompatibility enough time for public migration
-					if len(fsist.Flags) == 1 {
-						return &fsist.Flags[0]
-					}
-					return nil
-				}(),
-				Flags:      fsist.Flags,
-				Additional: fsist.Additional,
-			}); err != nil {
-				cerr <- err
-				return
+			if uerr := totw.RWUnlock(context.WithoutCancel(ctx)); uerr != nil {
+				uerr := &errs.ErrInternal{Sub: uerr}
+				logger.Error(ctx, "TOTW RW unlock", zap.Error(uerr))
 			}
-		}(relock, work, cerr, challengeID)
+			return err
+		}
+		clocks = append(clocks, clock)
 	}
-
-	// 5. Once all "relock" done, unlock RW TOTW
-	relock.Wait()
 	if err := totw.RWUnlock(context.WithoutCancel(ctx)); err != nil {
 		err := &errs.ErrInternal{Sub: err}
 		logger.Error(ctx, "TOTW RW unlock", zap.Error(err))
@@ -150,20 +154,121 @@ func (man *Manager) QueryInstance(req *QueryInstanceRequest, server InstanceMana
 	}
 	span.AddEvent("unlocked TOTW")
 
-	// 6. Once all "work" done, return error if any
-	work.Wait()
-	close(cerr)
-	var merri, merr error
-	for err := range cerr {
-		if err, ok := err.(*errs.ErrInternal); ok {
-			merri = multierr.Append(merri, err)
-			continue
+	// Secret outputs are only sent when the source queries its own instances
+	send := func(ist *fs.ClaimedInstance) error {
+		if req.SourceId != "" {
+			return server.Send(fromOwnedFS(ist.Instance, ist.SourceID))
+		}
+		return server.Send(FromFS(ist.Instance, ist.SourceID))
+	}
+
+	// 4. Walk through the instances, ordered by challenge then identity, from the
+	//    one after the page token. Without page size they are streamed as they are
+	//    read, elseway the walk stops once the page is full (plus one to know
+	//    whether there is a next page), such that only the page is buffered.
+	page := []*fs.ClaimedInstance{}
+	full := func() bool {
+		return req.PageSize != 0 && int64(len(page)) > req.PageSize
+	}
+	var serr error
+	for i, challengeID := range fschalls {
+		_, cspan := global.Tracer.Start(ctx, "reading-challenge", trace.WithAttributes(
+			attribute.String("challenge_id", challengeID),
+		))
+		f := *filter
+		if challengeID == lastChall {
+			f.After = lastIst
+		}
+		err := fs.WalkInstances(challengeID, &f, func(ist *fs.ClaimedInstance) bool {
+			if req.PageSize == 0 {
+				serr = send(ist)
+				return serr == nil
+			}
+			page = append(page, ist)
+			return !full()
+		})
+		unlock(clocks[i])
+		clocks[i] = nil
+		cspan.End()
+		if err != nil {
+			if err, ok := err.(*errs.ErrInternal); ok {
+				logger.Error(ctx, "reading challenge instances",
+					zap.String("challenge_id", challengeID),
+					zap.Error(err),
+				)
+				return errs.ErrInternalNoSub
+			}
+			return err
+		}
+		if serr != nil {
+			return serr
 		}
-		merr = multierr.Append(merr, err)
+		if full() {
+			break
+		}
+	}
+	if req.PageSize == 0 {
+		return nil
 	}
-	if merri != nil {
-		logger.Error(ctx, "reading challenges", zap.Error(err))
+
+	// 5. Send the page, along the token of the next one if there are more instances
+	next := ""
+	if full() {
+		page = page[:req.PageSize]
+		next = common.EncodePageToken(istKey(page[len(page)-1]))
+	}
+	if err := common.SetNextPageToken(server, next); err != nil {
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "setting next page token header", zap.Error(err))
 		return errs.ErrInternalNoSub
 	}
-	return merr // should remain nil as does not depend on user inputs, but makes it future-proof
+	for _, ist := range page {
+		if err := send(ist); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// keySep separates the challenge and the identity of an instance key, as it
+// can't be part of either.
+const keySep = "\x00"
+
+// istKey orders instances by challenge then identity.
+func istKey(ist *fs.ClaimedInstance) string {
+	return ist.ChallengeID + keySep + ist.Identity
+}
+
+func toFilter(req *QueryInstanceRequest) (*fs.InstanceFilter, error) {
+	sel, err := labels.Parse(req.LabelSelector)
+	if err != nil {
+		return nil, err
+	}
+	filter := &fs.InstanceFilter{
+		ChallengeIDs:   req.ChallengeIds,
+		SourceIDs:      req.SourceIds,
+		Claimed:        req.Claimed,
+		ExpiringBefore: ExpiringBefore(req.ExpiringBefore, req.Expired),
+		Labels:         sel,
+	}
+	if req.SourceId != "" {
+		filter.SourceIDs = []string{req.SourceId}
+	}
+	return filter, nil
+}
+
+// ExpiringBefore returns the date before which instances must expire to match
+// a query, if any. Expired instances are the ones that expire before now.
+func ExpiringBefore(before *timestamppb.Timestamp, expired bool) *time.Time {
+	var t *time.Time
+	if before != nil {
+		b := before.AsTime()
+		t = &b
+	}
+	if expired {
+		if now := time.Now(); t == nil || now.Before(*t) {
+			t = &now
+		}
+	}
+	return t
 }
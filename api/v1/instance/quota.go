@@ -0,0 +1,69 @@
+package instance
+
+import (
+	"context"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/ctfer-io/chall-manager/api/v1/common"
+	"github.com/ctfer-io/chall-manager/global"
+	errs "github.com/ctfer-io/chall-manager/pkg/errors"
+	"github.com/ctfer-io/chall-manager/pkg/fs"
+)
+
+// lockQuota checks the source can have one more instance according to the
+// challenge quota.
+// On success, the source lock is held until the returned release function is
+// called, once the new instance is claimed. This prevents concurrent creations
+// over multiple challenges from exceeding the quota.
+func lockQuota(ctx context.Context, fschall *fs.Challenge, sourceID string) (func(), error) {
+	quota := fschall.InstancesQuota()
+	if quota == 0 {
+		return func() {}, nil
+	}
+
+	slock, err := common.LockSource(ctx, sourceID)
+	if err != nil {
+		return nil, &errs.ErrInternal{Sub: err}
+	}
+	if err := slock.RWLock(ctx); err != nil {
+		return nil, &errs.ErrInternal{Sub: err}
+	}
+	release := sync.OnceFunc(func() {
+		if err := slock.RWUnlock(context.WithoutCancel(ctx)); err != nil {
+			err := &errs.ErrInternal{Sub: err}
+			global.Log().Error(ctx, "source RW unlock", zap.Error(err))
+		}
+	})
+
+	_, remaining, err := remainingQuota(fschall, sourceID)
+	if err != nil {
+		release()
+		return nil, &errs.ErrInternal{Sub: err}
+	}
+	if remaining == 0 {
+		release()
+		return nil, &errs.ErrQuotaExceeded{
+			SourceID: sourceID,
+			Max:      quota,
+		}
+	}
+	return release, nil
+}
+
+// remainingQuota returns the challenge quota, i.e. its own or the server-wide
+// one, along the number of instances the source can still have according to it.
+// The instances of the groups the source is a member of are counted.
+// If the quota is 0 there is no limit, thus nothing is counted.
+func remainingQuota(fschall *fs.Challenge, sourceID string) (quota, remaining int64, err error) {
+	quota = fschall.InstancesQuota()
+	if quota == 0 {
+		return 0, 0, nil
+	}
+	count, err := fs.CountInstances(sourceID)
+	if err != nil {
+		return 0, 0, err
+	}
+	return quota, max(quota-count, 0), nil
+}
@@ -0,0 +1,95 @@
+package instance
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ctfer-io/chall-manager/global"
+	errs "github.com/ctfer-io/chall-manager/pkg/errors"
+	"github.com/ctfer-io/chall-manager/pkg/fs"
+)
+
+func Test_U_RemainingQuota(t *testing.T) {
+	global.Conf.Directory = t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(global.Conf.Directory, "chall"), os.ModePerm))
+	global.Conf.MaxInstancesPerSource = 3
+	t.Cleanup(func() { global.Conf.MaxInstancesPerSource = 0 })
+
+	// The source owns an instance, and is a member of a group that owns another
+	two := int64(2)
+	for _, c := range []*fs.Challenge{
+		{ID: "owned", Shared: true},
+		{ID: "member", Shared: true},
+		{ID: "override", MaxInstancesPerSource: &two},
+		{ID: "unlimited", MaxInstancesPerSource: new(int64)},
+	} {
+		require.NoError(t, c.Save())
+	}
+	claim := func(challID, sourceID string, members ...string) {
+		fsist := &fs.Instance{ChallengeID: challID, Identity: "identity"}
+		require.NoError(t, fsist.Save())
+		require.NoError(t, fsist.Claim(sourceID))
+		for _, member := range members {
+			require.NoError(t, fsist.AddMember(member))
+		}
+	}
+	claim("owned", "player")
+	claim("member", "team", "player")
+
+	var tests = map[string]struct {
+		ChallengeID string
+		Quota       int64
+		Remaining   int64
+	}{
+		"server-wide": {
+			ChallengeID: "owned",
+			Quota:       3,
+			Remaining:   1,
+		},
+		"override": {
+			ChallengeID: "override",
+			Quota:       2,
+			Remaining:   0,
+		},
+		"unlimited": {
+			ChallengeID: "unlimited",
+			Quota:       0,
+			Remaining:   0,
+		},
+	}
+
+	for testname, tt := range tests {
+		t.Run(testname, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			fschall, err := fs.LoadChallenge(tt.ChallengeID)
+			require.NoError(err)
+
+			quota, remaining, err := remainingQuota(fschall, "player")
+			require.NoError(err)
+			assert.Equal(tt.Quota, quota)
+			assert.Equal(tt.Remaining, remaining)
+
+			// Creating an instance is refused once no more remains
+			release, err := lockQuota(context.Background(), fschall, "player")
+			if tt.Quota != 0 && tt.Remaining == 0 {
+				assert.IsType(&errs.ErrQuotaExceeded{}, err)
+				return
+			}
+			require.NoError(err)
+			release()
+		})
+	}
+
+	// Without a challenge override, the server-wide quota applies
+	quota, remaining, err := remainingQuota(&fs.Challenge{}, "team")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), quota)
+	assert.Equal(t, int64(2), remaining)
+}
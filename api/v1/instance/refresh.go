@@ -0,0 +1,395 @@
+package instance
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+
+	"github.com/ctfer-io/chall-manager/api/v1/common"
+	"github.com/ctfer-io/chall-manager/global"
+	errs "github.com/ctfer-io/chall-manager/pkg/errors"
+	"github.com/ctfer-io/chall-manager/pkg/events"
+	"github.com/ctfer-io/chall-manager/pkg/fs"
+	"github.com/ctfer-io/chall-manager/pkg/iac"
+	"github.com/ctfer-io/chall-manager/pkg/lock"
+)
+
+func (man *Manager) RefreshInstance(ctx context.Context, req *RefreshInstanceRequest) (*InstanceDrift, error) {
+	logger := global.Log()
+	ctx = global.WithChallengeID(ctx, req.ChallengeId)
+	ctx = global.WithSourceID(ctx, req.SourceId)
+	ctx = iac.WithPriority(ctx, iac.PriorityLow) // repairs come after players
+	span := trace.SpanFromContext(ctx)
+
+	// 1. Lock R TOTW
+	span.AddEvent("lock TOTW")
+	totw, err := common.LockTOTW(ctx)
+	if err != nil {
+		if totw.IsCanceled(err) {
+			return nil, nil
+		}
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "build TOTW lock", zap.Error(err))
+		return nil, errs.ErrInternalNoSub
+	}
+	if err := totw.RLock(ctx); err != nil {
+		if totw.IsCanceled(err) {
+			return nil, nil
+		}
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "TOTW R lock", zap.Error(err))
+		return nil, errs.ErrInternalNoSub
+	}
+	span.AddEvent("locked TOTW")
+
+	// 2. Lock R challenge
+	clock, err := common.LockChallenge(ctx, req.ChallengeId)
+	if err != nil {
+		if clock.IsCanceled(err) {
+			// If canceled, we need to recover
+			if err := totw.RUnlock(context.WithoutCancel(ctx)); err != nil {
+				err := &errs.ErrInternal{Sub: err}
+				logger.Error(ctx, "recovering from build challenge lock", zap.Error(err))
+				return nil, errs.ErrInternalNoSub
+			}
+			return nil, nil // recovery is successful, we can quit safely
+		}
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "build challenge lock", zap.Error(multierr.Combine(
+			totw.RUnlock(context.WithoutCancel(ctx)),
+			err,
+		)))
+		return nil, errs.ErrInternalNoSub
+	}
+	if err := clock.RLock(ctx); err != nil {
+		if clock.IsCanceled(err) {
+			// If canceled, we need to recover
+			if err := totw.RUnlock(context.WithoutCancel(ctx)); err != nil {
+				err := &errs.ErrInternal{Sub: err}
+				logger.Error(ctx, "recovering from challenge R lock", zap.Error(err))
+				return nil, errs.ErrInternalNoSub
+			}
+			return nil, nil // recovery is successful, we can quit safely
+		}
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "challenge R lock", zap.Error(multierr.Combine(
+			totw.RUnlock(context.WithoutCancel(ctx)),
+			err,
+		)))
+		return nil, errs.ErrInternalNoSub
+	}
+	defer func(lock lock.RWLock) {
+		if err := lock.RUnlock(context.WithoutCancel(ctx)); err != nil {
+			err := &errs.ErrInternal{Sub: err}
+			logger.Error(ctx, "challenge R unlock", zap.Error(err))
+		}
+	}(clock)
+
+	// 3. Unlock R TOTW
+	if err := totw.RUnlock(context.WithoutCancel(ctx)); err != nil {
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "TOTW R unlock", zap.Error(err))
+		return nil, errs.ErrInternalNoSub
+	}
+	span.AddEvent("unlocked TOTW")
+
+	// 4. If challenge/instance does not exist, return error
+	fschall, err := fs.LoadChallenge(req.ChallengeId)
+	if err != nil {
+		if err, ok := err.(*errs.ErrInternal); ok {
+			logger.Error(ctx, "loading challenge",
+				zap.Error(err),
+			)
+			return nil, errs.ErrInternalNoSub
+		}
+		return nil, err
+	}
+	id, owner, err := fs.ResolveInstance(req.ChallengeId, req.SourceId)
+	if err != nil {
+		if _, ok := err.(*errs.ErrInstanceExist); ok {
+			return nil, err
+		}
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "finding instance",
+			zap.Error(err),
+		)
+		return nil, errs.ErrInternalNoSub
+	}
+
+	// 5. Refresh the instance, and repair it if requested
+	ctx = global.WithIdentity(ctx, id)
+	drift, err := refreshInstance(ctx, fschall, id, owner, req.Repair)
+	if err != nil {
+		return nil, err
+	}
+
+	// 6. Unlock R challenge
+	//    -> defered after 2 (fault-tolerance)
+
+	return drift, nil
+}
+
+func (man *Manager) RefreshChallengeInstances(req *RefreshChallengeInstancesRequest, server InstanceManager_RefreshChallengeInstancesServer) error {
+	logger := global.Log()
+	ctx := global.WithChallengeID(server.Context(), req.ChallengeId)
+	ctx = iac.WithPriority(ctx, iac.PriorityLow) // sweeps come after players
+	span := trace.SpanFromContext(ctx)
+
+	// 1. Lock R TOTW
+	span.AddEvent("lock TOTW")
+	totw, err := common.LockTOTW(ctx)
+	if err != nil {
+		if totw.IsCanceled(err) {
+			return nil
+		}
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "build TOTW lock", zap.Error(err))
+		return errs.ErrInternalNoSub
+	}
+	if err := totw.RLock(ctx); err != nil {
+		if totw.IsCanceled(err) {
+			return nil
+		}
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "TOTW R lock", zap.Error(err))
+		return errs.ErrInternalNoSub
+	}
+	span.AddEvent("locked TOTW")
+
+	// 2. Lock R challenge
+	clock, err := common.LockChallenge(ctx, req.ChallengeId)
+	if err != nil {
+		if clock.IsCanceled(err) {
+			// If canceled, we need to recover
+			if err := totw.RUnlock(context.WithoutCancel(ctx)); err != nil {
+				err := &errs.ErrInternal{Sub: err}
+				logger.Error(ctx, "recovering from build challenge lock", zap.Error(err))
+				return errs.ErrInternalNoSub
+			}
+			return nil // recovery is successful, we can quit safely
+		}
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "build challenge lock", zap.Error(multierr.Combine(
+			totw.RUnlock(context.WithoutCancel(ctx)),
+			err,
+		)))
+		return errs.ErrInternalNoSub
+	}
+	if err := clock.RLock(ctx); err != nil {
+		if clock.IsCanceled(err) {
+			// If canceled, we need to recover
+			if err := totw.RUnlock(context.WithoutCancel(ctx)); err != nil {
+				err := &errs.ErrInternal{Sub: err}
+				logger.Error(ctx, "recovering from challenge R lock", zap.Error(err))
+				return errs.ErrInternalNoSub
+			}
+			return nil // recovery is successful, we can quit safely
+		}
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "challenge R lock", zap.Error(multierr.Combine(
+			totw.RUnlock(context.WithoutCancel(ctx)),
+			err,
+		)))
+		return errs.ErrInternalNoSub
+	}
+	defer func(lock lock.RWLock) {
+		if err := lock.RUnlock(context.WithoutCancel(ctx)); err != nil {
+			err := &errs.ErrInternal{Sub: err}
+			logger.Error(ctx, "challenge R unlock", zap.Error(err))
+		}
+	}(clock)
+
+	// 3. Unlock R TOTW
+	if err := totw.RUnlock(context.WithoutCancel(ctx)); err != nil {
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "TOTW R unlock", zap.Error(err))
+		return errs.ErrInternalNoSub
+	}
+	span.AddEvent("unlocked TOTW")
+
+	// 4. If challenge does not exist, return error
+	fschall, err := fs.LoadChallenge(req.ChallengeId)
+	if err != nil {
+		if err, ok := err.(*errs.ErrInternal); ok {
+			logger.Error(ctx, "loading challenge",
+				zap.Error(err),
+			)
+			return errs.ErrInternalNoSub
+		}
+		return err
+	}
+	ists, err := fs.ListInstances(req.ChallengeId)
+	if err != nil {
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "listing instances",
+			zap.Error(err),
+		)
+		return errs.ErrInternalNoSub
+	}
+
+	// 5. Refresh all instances, claimed and pooled, and send their drift as
+	//    soon as known
+	logger.Info(ctx, "refreshing challenge instances",
+		zap.Int("instances", len(ists)),
+		zap.Bool("repair", req.Repair),
+	)
+	mx := &sync.Mutex{}
+	var sendErr error
+	work := &sync.WaitGroup{}
+	work.Add(len(ists))
+	for _, identity := range ists {
+		go func(identity string) {
+			defer work.Done()
+
+			sourceID, _ := fs.LookupClaim(req.ChallengeId, identity)
+			ctx := global.WithIdentity(ctx, identity)
+			if sourceID != "" {
+				ctx = global.WithSourceID(ctx, sourceID)
+			}
+
+			drift, err := refreshInstance(ctx, fschall, identity, sourceID, req.Repair)
+			if err != nil {
+				drift = newDrift(req.ChallengeId, sourceID, identity)
+				reason := err.Error()
+				drift.Error = &reason
+			}
+
+			mx.Lock()
+			defer mx.Unlock()
+			if sendErr == nil {
+				sendErr = server.Send(drift)
+			}
+		}(identity)
+	}
+	work.Wait()
+
+	// 6. Unlock R challenge
+	//    -> defered after 2 (fault-tolerance)
+
+	return sendErr
+}
+
+// refreshInstance refreshes an instance under its RW lock, then repairs it if
+// requested and it drifted.
+// Refresh and repair failures are reported in the drift, only the failures to
+// access the instance are returned.
+func refreshInstance(ctx context.Context, fschall *fs.Challenge, identity, sourceID string, repair bool) (*InstanceDrift, error) {
+	logger := global.Log()
+
+	// 1. Lock RW instance
+	ilock, err := common.LockInstance(ctx, fschall.ID, identity)
+	if err != nil {
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "build instance lock", zap.Error(err))
+		return nil, errs.ErrInternalNoSub
+	}
+	if err := ilock.RWLock(ctx); err != nil {
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "challenge instance RW lock", zap.Error(err))
+		return nil, errs.ErrInternalNoSub
+	}
+	defer func(lock lock.RWLock) {
+		if err := lock.RWUnlock(context.WithoutCancel(ctx)); err != nil {
+			err := &errs.ErrInternal{Sub: err}
+			logger.Error(ctx, "instance RW unlock", zap.Error(err))
+		}
+	}(ilock)
+
+	// 2. If instance does not exist, return error
+	fsist, err := fs.LoadInstance(fschall.ID, identity)
+	if err != nil {
+		if err, ok := err.(*errs.ErrInternal); ok {
+			logger.Error(ctx, "loading challenge instance",
+				zap.Error(err),
+			)
+			return nil, errs.ErrInternalNoSub
+		}
+		return nil, err
+	}
+	if !fsist.IsReady() {
+		return nil, fmt.Errorf("challenge instance can't be refreshed as it is %s", fsist.Status)
+	}
+
+	// 3. Refresh the instance state
+	drift := newDrift(fschall.ID, sourceID, identity)
+	start := time.Now()
+	diff, rerr := iac.Refresh(ctx, fschall, fsist)
+	audit(ctx, fs.OpRefresh, fschall.ID, sourceID, start, rerr)
+	if rerr != nil {
+		logger.Error(ctx, "refreshing instance", zap.Error(rerr))
+		reason := rerr.Error()
+		drift.Error = &reason
+		return drift, nil
+	}
+	drift.Drifted = diff.Drifted()
+	drift.Updates = diff.Updates + diff.Replaces
+	drift.Deletes = diff.Deletes
+	drift.Sames = diff.Sames
+
+	// 4. Repair it if requested and drifted
+	if drift.Drifted && repair {
+		start := time.Now()
+		rerr = iac.Repair(ctx, fschall, fsist)
+		audit(ctx, fs.OpRepair, fschall.ID, sourceID, start, rerr)
+		if rerr != nil {
+			logger.Error(ctx, "repairing instance", zap.Error(rerr))
+			fsist.Status = fs.StatusFailed
+			fsist.Reason = rerr.Error()
+			reason := rerr.Error()
+			drift.Error = &reason
+		} else {
+			drift.Repaired = true
+		}
+	}
+	if drift.Drifted {
+		common.DriftsCounter().Add(ctx, 1,
+			metric.WithAttributes(
+				attribute.String("challenge", fschall.ID),
+				attribute.Bool("repaired", drift.Repaired),
+			),
+		)
+		logger.Warn(ctx, "instance drifted",
+			zap.Int64("updates", drift.Updates),
+			zap.Int64("deletes", drift.Deletes),
+			zap.Bool("repaired", drift.Repaired),
+		)
+	}
+
+	// 5. Save the refreshed (or repaired) state
+	if err := fsist.Save(); err != nil {
+		logger.Error(ctx, "exporting instance information to filesystem",
+			zap.Error(err),
+		)
+		return nil, errs.ErrInternalNoSub
+	}
+	if drift.Drifted && repair {
+		typ := events.Updated
+		if rerr != nil {
+			typ = events.Failed
+		}
+		events.Publish(ctx, events.New(typ, sourceID, fsist))
+	}
+
+	// 6. Unlock RW instance
+	//    -> defered after 1 (fault-tolerance)
+
+	return drift, nil
+}
+
+func newDrift(challID, sourceID, identity string) *InstanceDrift {
+	drift := &InstanceDrift{
+		ChallengeId: challID,
+		Identity:    identity,
+	}
+	if sourceID != "" {
+		drift.SourceId = &sourceID
+	}
+	return drift
+}
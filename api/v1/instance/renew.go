@@ -9,19 +9,24 @@ import (
 	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/multierr"
 	"go.uber.org/zap"
-	"google.golang.org/protobuf/types/known/timestamppb"
+	"google.golang.org/protobuf/types/known/durationpb"
 
 	"github.com/ctfer-io/chall-manager/api/v1/common"
 	"github.com/ctfer-io/chall-manager/global"
 	errs "github.com/ctfer-io/chall-manager/pkg/errors"
+	"github.com/ctfer-io/chall-manager/pkg/events"
 	"github.com/ctfer-io/chall-manager/pkg/fs"
+	"github.com/ctfer-io/chall-manager/pkg/iac"
 	"github.com/ctfer-io/chall-manager/pkg/lock"
 )
 
-func (man *Manager) RenewInstance(ctx context.Context, req *RenewInstanceRequest) (*Instance, error) {
+func (man *Manager) RenewInstance(ctx context.Context, req *RenewInstanceRequest) (_ *Instance, err error) {
 	logger := global.Log()
 	ctx = global.WithChallengeID(ctx, req.ChallengeId)
 	span := trace.SpanFromContext(ctx)
+	defer func(start time.Time) {
+		audit(ctx, fs.OpRenew, req.ChallengeId, req.SourceId, start, err)
+	}(time.Now())
 
 	// 1. Lock R TOTW
 	span.AddEvent("lock TOTW")
@@ -106,7 +111,7 @@ func (man *Manager) RenewInstance(ctx context.Context, req *RenewInstanceRequest
 		}
 		return nil, err
 	}
-	id, err := fs.FindInstance(req.ChallengeId, req.SourceId)
+	id, owner, err := fs.ResolveInstance(req.ChallengeId, req.SourceId)
 	if err != nil {
 		err := &errs.ErrInternal{Sub: err}
 		logger.Error(ctx, "finding instance",
@@ -155,6 +160,14 @@ func (man *Manager) RenewInstance(ctx context.Context, req *RenewInstanceRequest
 	}
 
 	// 7. Set new until to now + challenge.timeout if any
+	if !fsist.IsReady() {
+		return nil, &errs.ErrInstanceStatus{
+			ChallengeID: req.ChallengeId,
+			Identity:    id,
+			Operation:   "renewed",
+			Status:      string(fsist.Status),
+		}
+	}
 	if fschall.Timeout == nil {
 		// This makes sure renewal is possible thanks to a timeout
 		return nil, fmt.Errorf("challenge %s does not accept renewal", req.ChallengeId)
@@ -164,8 +177,32 @@ func (man *Manager) RenewInstance(ctx context.Context, req *RenewInstanceRequest
 		// This makes sure fsist.Until > now <=> fsist.Until-now > 0
 		return nil, errors.New("challenge instance can't be renewed as it expired")
 	}
+
+	// 8. Enforce the renewal policy, and cap the requested duration by the challenge timeout
+	until, err := renewUntil(fschall, fsist, req.Duration, now)
+	if err != nil {
+		return nil, err
+	}
 	fsist.LastRenew = now
-	fsist.Until = common.ComputeUntil(fschall.Until, fschall.Timeout)
+	fsist.Until = until
+	fsist.Renews++
+
+	// 9. Migrate the instance if it lags behind the challenge, e.g. after a deferred
+	//    update. On failure, the renewal still applies and the migration is retried
+	//    on the next one.
+	if fsist.Outdated(fschall.Revision) {
+		logger.Info(ctx, "migrating instance",
+			zap.Int64("from", fsist.Revision),
+			zap.Int64("to", fschall.Revision),
+		)
+		if err := iac.Update(ctx, fsist.RunningScenario(fschall.Scenario), "deferred", fschall, fsist); err != nil {
+			logger.Error(ctx, "migrating instance",
+				zap.Error(err),
+			)
+		} else {
+			events.Publish(ctx, events.New(events.Updated, owner, fsist))
+		}
+	}
 
 	logger.Info(ctx, "renewing instance")
 	if err := fsist.Save(); err != nil {
@@ -175,28 +212,46 @@ func (man *Manager) RenewInstance(ctx context.Context, req *RenewInstanceRequest
 		return nil, errs.ErrInternalNoSub
 	}
 
-	// 8. Unlock RW instance
-	//    -> defered after 5 (fault-tolerance)
-	// 9. Unlock R challenge
-	//    -> defered after 2 (fault-tolerance)
-
-	var until *timestamppb.Timestamp
-	if fsist.Until != nil {
-		until = timestamppb.New(*fsist.Until)
-	}
-	return &Instance{
-		ChallengeId:    req.ChallengeId,
-		SourceId:       req.SourceId,
-		Since:          timestamppb.New(fsist.Since),
-		LastRenew:      timestamppb.New(fsist.LastRenew),
-		Until:          until,
-		ConnectionInfo: fsist.ConnectionInfo,
-		Flag: func() *string { // kept for retrocompatibility enough time for public migration
-			if len(fsist.Flags) == 1 {
-				return &fsist.Flags[0]
+	events.Publish(ctx, events.New(events.Renewed, owner, fsist))
+
+	// 10. Unlock RW instance
+	//     -> defered after 5 (fault-tolerance)
+	// 11. Unlock R challenge
+	//     -> defered after 2 (fault-tolerance)
+
+	return fromOwnedFS(fsist, owner), nil
+}
+
+// renewUntil returns the date until which the instance is renewed, given the
+// challenge renewal policy and the requested duration if any.
+// The lifetime of an instance, and its renewals, are counted from its claim.
+func renewUntil(fschall *fs.Challenge, fsist *fs.Instance, duration *durationpb.Duration, now time.Time) (*time.Time, error) {
+	if fschall.MaxRenews != 0 && fsist.Renews >= fschall.MaxRenews {
+		return nil, &errs.ErrRenewPolicy{
+			ChallengeID: fschall.ID,
+			Reason:      fmt.Sprintf("maximum number of renewals reached (%d)", fschall.MaxRenews),
+		}
+	}
+	timeout := *fschall.Timeout
+	if duration != nil {
+		d := duration.AsDuration()
+		if d <= 0 {
+			return nil, errors.New("renewal duration must be positive")
+		}
+		timeout = min(timeout, d)
+	}
+	until := common.ComputeUntil(fschall.Until, &timeout)
+	if fschall.MaxLifetime != nil {
+		end := fsist.Since.Add(*fschall.MaxLifetime)
+		if !now.Before(end) {
+			return nil, &errs.ErrRenewPolicy{
+				ChallengeID: fschall.ID,
+				Reason:      fmt.Sprintf("maximum lifetime reached (%s)", *fschall.MaxLifetime),
 			}
-			return nil
-		}(),
-		Flags: fsist.Flags,
-	}, nil
+		}
+		if until.After(end) {
+			until = &end
+		}
+	}
+	return until, nil
 }
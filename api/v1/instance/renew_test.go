@@ -0,0 +1,62 @@
+package instance
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	errs "github.com/ctfer-io/chall-manager/pkg/errors"
+	"github.com/ctfer-io/chall-manager/pkg/fs"
+)
+
+func Test_U_RenewUntil(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	timeout, lifetime := 30*time.Minute, time.Hour
+	fschall := &fs.Challenge{
+		ID:          "chall",
+		Timeout:     &timeout,
+		MaxRenews:   2,
+		MaxLifetime: &lifetime,
+	}
+
+	t.Run("pooled-claim", func(t *testing.T) {
+		t.Parallel()
+		assert := assert.New(t)
+		require := require.New(t)
+
+		// The instance sat in the pool for hours, past its maximum lifetime
+		fsist := &fs.Instance{Since: now.Add(-3 * time.Hour), Renews: 2}
+		_, err := renewUntil(fschall, fsist, nil, now)
+		assert.IsType(&errs.ErrRenewPolicy{}, err)
+
+		// Once claimed, its lifetime and renewals start over
+		fsist.Start(now, nil)
+		until, err := renewUntil(fschall, fsist, nil, now)
+		require.NoError(err)
+		assert.Equal(now.Add(timeout).Round(time.Second), until.Round(time.Second))
+	})
+
+	t.Run("max-lifetime", func(t *testing.T) {
+		t.Parallel()
+		assert := assert.New(t)
+		require := require.New(t)
+
+		fsist := &fs.Instance{Since: now.Add(-45 * time.Minute)}
+		until, err := renewUntil(fschall, fsist, nil, now)
+		require.NoError(err)
+		assert.Equal(now.Add(15*time.Minute), *until)
+	})
+
+	t.Run("max-renews", func(t *testing.T) {
+		t.Parallel()
+		assert := assert.New(t)
+
+		fsist := &fs.Instance{Since: now, Renews: 2}
+		_, err := renewUntil(fschall, fsist, nil, now)
+		assert.IsType(&errs.ErrRenewPolicy{}, err)
+	})
+}
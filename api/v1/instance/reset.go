@@ -0,0 +1,240 @@
+package instance
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+
+	"github.com/ctfer-io/chall-manager/api/v1/common"
+	"github.com/ctfer-io/chall-manager/global"
+	errs "github.com/ctfer-io/chall-manager/pkg/errors"
+	"github.com/ctfer-io/chall-manager/pkg/events"
+	"github.com/ctfer-io/chall-manager/pkg/fs"
+	"github.com/ctfer-io/chall-manager/pkg/iac"
+	"github.com/ctfer-io/chall-manager/pkg/lock"
+)
+
+func (man *Manager) ResetInstance(ctx context.Context, req *ResetInstanceRequest) (_ *Instance, err error) {
+	logger := global.Log()
+	ctx = global.WithChallengeID(ctx, req.ChallengeId)
+	ctx = iac.WithPriority(ctx, iac.PriorityHigh) // players are served first
+	span := trace.SpanFromContext(ctx)
+	defer func(start time.Time) {
+		audit(ctx, fs.OpReset, req.ChallengeId, req.SourceId, start, err)
+	}(time.Now())
+
+	// 1. Lock R TOTW
+	span.AddEvent("lock TOTW")
+	totw, err := common.LockTOTW(ctx)
+	if err != nil {
+		if totw.IsCanceled(err) {
+			return nil, nil
+		}
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "build TOTW lock", zap.Error(err))
+		return nil, errs.ErrInternalNoSub
+	}
+	if err := totw.RLock(ctx); err != nil {
+		if totw.IsCanceled(err) {
+			return nil, nil
+		}
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "TOTW R lock", zap.Error(err))
+		return nil, errs.ErrInternalNoSub
+	}
+	span.AddEvent("locked TOTW")
+
+	// 2. Lock R challenge
+	clock, err := common.LockChallenge(ctx, req.ChallengeId)
+	if err != nil {
+		if clock.IsCanceled(err) {
+			// If canceled, we need to recover
+			if err := totw.RUnlock(context.WithoutCancel(ctx)); err != nil {
+				err := &errs.ErrInternal{Sub: err}
+				logger.Error(ctx, "recovering from build challenge lock", zap.Error(err))
+				return nil, errs.ErrInternalNoSub
+			}
+			return nil, nil // recovery is successful, we can quit safely
+		}
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "build challenge lock", zap.Error(multierr.Combine(
+			totw.RUnlock(context.WithoutCancel(ctx)),
+			err,
+		)))
+		return nil, errs.ErrInternalNoSub
+	}
+	if err := clock.RLock(ctx); err != nil {
+		if clock.IsCanceled(err) {
+			// If canceled, we need to recover
+			if err := totw.RUnlock(context.WithoutCancel(ctx)); err != nil {
+				err := &errs.ErrInternal{Sub: err}
+				logger.Error(ctx, "recovering from challenge R lock", zap.Error(err))
+				return nil, errs.ErrInternalNoSub
+			}
+			return nil, nil // recovery is successful, we can quit safely
+		}
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "challenge R lock", zap.Error(multierr.Combine(
+			totw.RUnlock(context.WithoutCancel(ctx)),
+			err,
+		)))
+		return nil, errs.ErrInternalNoSub
+	}
+	defer func(lock lock.RWLock) {
+		if err := lock.RUnlock(context.WithoutCancel(ctx)); err != nil {
+			err := &errs.ErrInternal{Sub: err}
+			logger.Error(ctx, "challenge R unlock", zap.Error(err))
+		}
+	}(clock)
+
+	// 3. Unlock R TOTW
+	if err := totw.RUnlock(context.WithoutCancel(ctx)); err != nil {
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "TOTW R unlock", zap.Error(err))
+		return nil, errs.ErrInternalNoSub
+	}
+	span.AddEvent("unlocked TOTW")
+
+	// 4. If challenge does not exist, return error
+	fschall, err := fs.LoadChallenge(req.ChallengeId)
+	if err != nil {
+		if err, ok := err.(*errs.ErrInternal); ok {
+			logger.Error(ctx, "loading challenge",
+				zap.Error(err),
+			)
+			return nil, errs.ErrInternalNoSub
+		}
+		return nil, err
+	}
+	id, owner, err := fs.ResolveInstance(req.ChallengeId, req.SourceId)
+	if err != nil {
+		if _, ok := err.(*errs.ErrInstanceExist); ok {
+			return nil, err
+		}
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "finding instance",
+			zap.Error(err),
+		)
+		return nil, errs.ErrInternalNoSub
+	}
+
+	// 5. Lock RW instance
+	ctx = global.WithSourceID(ctx, req.SourceId)
+	ctx = global.WithIdentity(ctx, id)
+	ilock, err := common.LockInstance(ctx, req.ChallengeId, id)
+	if err != nil {
+		if ilock.IsCanceled(err) {
+			return nil, nil
+		}
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "build challenge lock", zap.Error(err))
+		return nil, errs.ErrInternalNoSub
+	}
+	if err := ilock.RWLock(ctx); err != nil {
+		if ilock.IsCanceled(err) {
+			return nil, nil
+		}
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "challenge instance RW lock", zap.Error(err))
+		return nil, errs.ErrInternalNoSub
+	}
+	defer func(lock lock.RWLock) {
+		if err := lock.RWUnlock(context.WithoutCancel(ctx)); err != nil {
+			err := &errs.ErrInternal{Sub: err}
+			logger.Error(ctx, "instance RW unlock", zap.Error(err))
+		}
+	}(ilock)
+
+	// 6. If instance does not exist, return error (+ Unlock RW instance, Unlock R challenge)
+	fsist, err := fs.LoadInstance(req.ChallengeId, id)
+	if err != nil {
+		if err, ok := err.(*errs.ErrInternal); ok {
+			logger.Error(ctx, "loading challenge instance",
+				zap.Error(err),
+			)
+			return nil, errs.ErrInternalNoSub
+		}
+		return nil, err
+	}
+
+	// 7. Reset the instance, failed ones included as it may repair them
+	switch fsist.Status {
+	case fs.StatusProvisioning, fs.StatusDeleting:
+		return nil, &errs.ErrInstanceStatus{
+			ChallengeID: req.ChallengeId,
+			Identity:    id,
+			Operation:   "reset",
+			Status:      string(fsist.Status),
+		}
+	}
+
+	logger.Info(ctx, "resetting instance",
+		zap.Bool("new-identity", req.NewIdentity),
+	)
+	oldIst := *fsist
+	rerr := iac.Reset(ctx, fschall, fsist, req.NewIdentity)
+	if rerr != nil {
+		logger.Error(ctx, "resetting instance", zap.Error(rerr))
+		fsist.Status = fs.StatusFailed
+		fsist.Reason = rerr.Error()
+	} else {
+		fsist.Status = fs.StatusReady
+		fsist.Reason = ""
+	}
+
+	// 8. Save the instance and claim it back, as the reset removed it from filesystem.
+	// On failure it is kept as failed for the source not to lose it.
+	if err := fsist.Save(); err != nil {
+		logger.Error(ctx, "exporting instance information to filesystem",
+			zap.Error(err),
+		)
+		return nil, errs.ErrInternalNoSub
+	}
+	if err := fsist.Claim(owner); err != nil {
+		if _, ok := err.(*fs.ErrAlreadyClaimed); !ok {
+			logger.Error(ctx, "claiming instance",
+				zap.Error(err),
+			)
+			return nil, errs.ErrInternalNoSub
+		}
+	}
+	if err := fsist.SaveMembers(); err != nil {
+		logger.Error(ctx, "saving instance members",
+			zap.Error(err),
+		)
+		return nil, errs.ErrInternalNoSub
+	}
+	if !slices.Equal(oldIst.Flags, fsist.Flags) {
+		// Keep track of the previous flags for leak detection, best effort
+		if err := oldIst.ArchiveFlags(owner); err != nil {
+			logger.Error(ctx, "archiving instance flags", zap.Error(err))
+		}
+	}
+	if oldIst.Identity != fsist.Identity {
+		// Make sure nothing remains of the previous identity
+		if err := oldIst.Delete(); err != nil {
+			logger.Error(ctx, "removing previous instance",
+				zap.Error(err),
+			)
+			return nil, errs.ErrInternalNoSub
+		}
+	}
+
+	if rerr != nil {
+		events.Publish(ctx, events.New(events.Failed, owner, fsist))
+		return nil, errs.ErrInternalNoSub
+	}
+	events.Publish(ctx, events.New(events.Reset, owner, fsist))
+	logger.Info(ctx, "instance reset successfully")
+
+	// 9. Unlock RW instance
+	//    -> defered after 5 (fault-tolerance)
+	// 10. Unlock R challenge
+	//    -> defered after 2 (fault-tolerance)
+
+	return fromOwnedFS(fsist, owner), nil
+}
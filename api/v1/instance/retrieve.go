@@ -6,7 +6,6 @@ import (
 	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/multierr"
 	"go.uber.org/zap"
-	"google.golang.org/protobuf/types/known/timestamppb"
 
 	"github.com/ctfer-io/chall-manager/api/v1/common"
 	"github.com/ctfer-io/chall-manager/global"
@@ -90,7 +89,7 @@ func (man *Manager) RetrieveInstance(ctx context.Context, req *RetrieveInstanceR
 	span.AddEvent("unlocked TOTW")
 
 	// 4. If challenge/instance does not exist, return error
-	id, err := fs.FindInstance(req.ChallengeId, req.SourceId)
+	id, owner, err := fs.ResolveInstance(req.ChallengeId, req.SourceId)
 	if err != nil {
 		// If instance not found, is not an error
 		if _, ok := err.(*errs.ErrInstanceExist); ok {
@@ -183,24 +182,5 @@ func (man *Manager) RetrieveInstance(ctx context.Context, req *RetrieveInstanceR
 	// 7. Unlock R instance
 	//    -> defered after 4 (fault-tolerance)
 
-	var until *timestamppb.Timestamp
-	if fsist.Until != nil {
-		until = timestamppb.New(*fsist.Until)
-	}
-	return &Instance{
-		ChallengeId:    req.ChallengeId,
-		SourceId:       req.SourceId,
-		Since:          timestamppb.New(fsist.Since),
-		LastRenew:      timestamppb.New(fsist.LastRenew),
-		Until:          until,
-		ConnectionInfo: fsist.ConnectionInfo,
-		Flag: func() *string { // kept for retrocompatibility enough time for public migration
-			if len(fsist.Flags) == 1 {
-				return &fsist.Flags[0]
-			}
-			return nil
-		}(),
-		Flags:      fsist.Flags,
-		Additional: fsist.Additional,
-	}, nil
+	return fromOwnedFS(fsist, owner), nil
 }
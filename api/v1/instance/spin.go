@@ -27,6 +27,7 @@ func SpinUp(ctx context.Context, challengeID string) {
 	ctx = global.WithChallengeID(ctx, challengeID)
 	ctx = global.WithoutSourceID(ctx)
 	ctx = global.WithoutIdentity(ctx)
+	ctx = iac.WithPriority(ctx, iac.PriorityLow) // pool refills come after players
 
 	// Track span of spinning up a new instance
 	ctx, span := global.Tracer.Start(ctx, "pool-spin-up", trace.WithAttributes(
@@ -101,12 +102,20 @@ func SpinUp(ctx context.Context, challengeID string) {
 	if fschall.Until != nil && time.Now().After(*fschall.Until) {
 		return
 	}
+	// Skip pre-provision if challenge is under maintenance, but not if it is
+	// not opened yet: the pool is provisioned ahead such that it is ready on time.
+	if fschall.Maintenance {
+		return
+	}
 
 	// 5. Create identity
 	id := identity.New()
 	ctx = global.WithIdentity(ctx, id)
 
-	// 10. Spin up instance
+	// 10. Spin up instance, tracking it such that it can be canceled
+	ctx, done := iac.Track(ctx, challengeID, id)
+	defer done()
+
 	stack, err := iac.NewStack(ctx, fschall, id)
 	if err != nil {
 		logger.Error(ctx, "building new stack",
@@ -121,7 +130,12 @@ func SpinUp(ctx context.Context, challengeID string) {
 		return
 	}
 
-	sr, err := stack.Up(ctx)
+	// The instance is not registered until up, so its operations logs are
+	// retained at the challenge level such that a failure can be diagnosed.
+	sr, err := upOrCleanup(ctx, stack, challengeID, "", &fs.RetainedLogs{
+		ChallengeID: challengeID,
+		Identity:    id,
+	})
 	if err != nil {
 		logger.Error(ctx, "stack up",
 			zap.Error(err),
@@ -137,6 +151,8 @@ func SpinUp(ctx context.Context, challengeID string) {
 		LastRenew:   now,
 		Until:       common.ComputeUntil(fschall.Until, fschall.Timeout),
 		Additional:  nil,
+		Scenario:    fschall.Scenario,
+		Revision:    fschall.Revision,
 	}
 	if err := stack.Export(ctx, sr, fsist); err != nil {
 		logger.Error(ctx, "extracting stack info",
@@ -155,5 +171,11 @@ func SpinUp(ctx context.Context, challengeID string) {
 		logger.Error(ctx, "exporting instance information to filesystem",
 			zap.Error(err),
 		)
+		return
+	}
+	if err := fsist.AdoptOperationLogs(); err != nil {
+		logger.Error(ctx, "moving operation logs alongside instance",
+			zap.Error(err),
+		)
 	}
 }
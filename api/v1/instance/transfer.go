@@ -0,0 +1,252 @@
+package instance
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+
+	"github.com/ctfer-io/chall-manager/api/v1/common"
+	"github.com/ctfer-io/chall-manager/global"
+	errs "github.com/ctfer-io/chall-manager/pkg/errors"
+	"github.com/ctfer-io/chall-manager/pkg/events"
+	"github.com/ctfer-io/chall-manager/pkg/fs"
+	"github.com/ctfer-io/chall-manager/pkg/lock"
+)
+
+// TransferInstance rewrites the claim of an instance for another source.
+// The challenge is RW locked such that the target source can't concurrently
+// create an instance of its own.
+func (man *Manager) TransferInstance(ctx context.Context, req *TransferInstanceRequest) (_ *Instance, err error) {
+	logger := global.Log()
+	ctx = global.WithChallengeID(ctx, req.ChallengeId)
+	ctx = global.WithSourceID(ctx, req.FromSourceId)
+	span := trace.SpanFromContext(ctx)
+	defer func(start time.Time) {
+		audit(ctx, fs.OpTransfer, req.ChallengeId, req.FromSourceId, start, err)
+	}(time.Now())
+
+	if req.FromSourceId == "" || req.ToSourceId == "" || req.FromSourceId == req.ToSourceId {
+		return nil, errors.New("sources must be set and differ")
+	}
+	challengeID := req.ChallengeId
+
+	// 1. Lock R TOTW
+	span.AddEvent("lock TOTW")
+	totw, err := common.LockTOTW(ctx)
+	if err != nil {
+		if totw.IsCanceled(err) {
+			return nil, nil
+		}
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "build TOTW lock", zap.Error(err))
+		return nil, errs.ErrInternalNoSub
+	}
+	if err := totw.RLock(ctx); err != nil {
+		if totw.IsCanceled(err) {
+			return nil, nil
+		}
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "TOTW R lock", zap.Error(err))
+		return nil, errs.ErrInternalNoSub
+	}
+	span.AddEvent("locked TOTW")
+
+	// 2. Lock RW challenge
+	clock, err := common.LockChallenge(ctx, challengeID)
+	if err != nil {
+		if clock.IsCanceled(err) {
+			// If canceled, we need to recover
+			if err := totw.RUnlock(context.WithoutCancel(ctx)); err != nil {
+				err := &errs.ErrInternal{Sub: err}
+				logger.Error(ctx, "recovering from build challenge lock", zap.Error(err))
+				return nil, errs.ErrInternalNoSub
+			}
+			return nil, nil // recovery is successful, we can quit safely
+		}
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "build challenge lock", zap.Error(multierr.Combine(
+			totw.RUnlock(context.WithoutCancel(ctx)),
+			err,
+		)))
+		return nil, errs.ErrInternalNoSub
+	}
+	if err := clock.RWLock(ctx); err != nil {
+		if clock.IsCanceled(err) {
+			// If canceled, we need to recover
+			if err := totw.RUnlock(context.WithoutCancel(ctx)); err != nil {
+				err := &errs.ErrInternal{Sub: err}
+				logger.Error(ctx, "recovering from challenge RW lock", zap.Error(err))
+				return nil, errs.ErrInternalNoSub
+			}
+			return nil, nil // recovery is successful, we can quit safely
+		}
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "challenge RW lock", zap.Error(multierr.Combine(
+			totw.RUnlock(context.WithoutCancel(ctx)),
+			err,
+		)))
+		return nil, errs.ErrInternalNoSub
+	}
+	defer func(lock lock.RWLock) {
+		if err := lock.RWUnlock(context.WithoutCancel(ctx)); err != nil {
+			err := &errs.ErrInternal{Sub: err}
+			logger.Error(ctx, "challenge RW unlock", zap.Error(err))
+		}
+	}(clock)
+
+	// 3. Unlock R TOTW
+	if err := totw.RUnlock(context.WithoutCancel(ctx)); err != nil {
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "TOTW R unlock", zap.Error(err))
+		return nil, errs.ErrInternalNoSub
+	}
+	span.AddEvent("unlocked TOTW")
+
+	// 4. If the source does not own an instance, or the target already has one, return error
+	fschall, err := fs.LoadChallenge(challengeID)
+	if err != nil {
+		if err, ok := err.(*errs.ErrInternal); ok {
+			logger.Error(ctx, "loading challenge",
+				zap.Error(err),
+			)
+			return nil, errs.ErrInternalNoSub
+		}
+		return nil, err
+	}
+	id, owner, err := fs.ResolveInstance(challengeID, req.FromSourceId)
+	if err != nil {
+		if _, ok := err.(*errs.ErrInstanceExist); ok {
+			return nil, err
+		}
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "finding instance",
+			zap.Error(err),
+		)
+		return nil, errs.ErrInternalNoSub
+	}
+	if owner != req.FromSourceId {
+		return nil, &errs.ErrNotOwner{
+			ChallengeID: challengeID,
+			SourceID:    req.FromSourceId,
+		}
+	}
+	// The target can't own an instance, nor be a member of a group that does
+	if _, _, err := fs.ResolveInstance(challengeID, req.ToSourceId); err == nil {
+		return nil, &errs.ErrInstanceExist{
+			ChallengeID: challengeID,
+			SourceID:    req.ToSourceId,
+			Exist:       true,
+		}
+	} else if _, ok := err.(*errs.ErrInstanceExist); !ok {
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "finding target instance",
+			zap.Error(err),
+		)
+		return nil, errs.ErrInternalNoSub
+	}
+	releaseQuota, err := lockQuota(ctx, fschall, req.ToSourceId)
+	if err != nil {
+		if _, ok := err.(*errs.ErrInternal); ok {
+			logger.Error(ctx, "checking target source quota", zap.Error(err))
+			return nil, errs.ErrInternalNoSub
+		}
+		return nil, err
+	}
+	defer releaseQuota()
+
+	// 5. Lock RW instance
+	ctx = global.WithIdentity(ctx, id)
+	ilock, err := common.LockInstance(ctx, challengeID, id)
+	if err != nil {
+		if ilock.IsCanceled(err) {
+			return nil, nil
+		}
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "build instance lock", zap.Error(err))
+		return nil, errs.ErrInternalNoSub
+	}
+	if err := ilock.RWLock(ctx); err != nil {
+		if ilock.IsCanceled(err) {
+			return nil, nil
+		}
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "challenge instance RW lock", zap.Error(err))
+		return nil, errs.ErrInternalNoSub
+	}
+	defer func(lock lock.RWLock) {
+		if err := lock.RWUnlock(context.WithoutCancel(ctx)); err != nil {
+			err := &errs.ErrInternal{Sub: err}
+			logger.Error(ctx, "instance RW unlock", zap.Error(err))
+		}
+	}(ilock)
+
+	// 6. Rewrite the claim
+	fsist, err := fs.LoadInstance(challengeID, id)
+	if err != nil {
+		if err, ok := err.(*errs.ErrInternal); ok {
+			logger.Error(ctx, "loading challenge instance",
+				zap.Error(err),
+			)
+			return nil, errs.ErrInternalNoSub
+		}
+		return nil, err
+	}
+	switch fsist.Status {
+	case fs.StatusProvisioning, fs.StatusDeleting:
+		return nil, &errs.ErrInstanceStatus{
+			ChallengeID: challengeID,
+			Identity:    id,
+			Operation:   "transferred",
+			Status:      string(fsist.Status),
+		}
+	}
+	// Keep track of the flags as issued to the previous owner, best effort
+	if err := fsist.ArchiveFlags(req.FromSourceId); err != nil {
+		logger.Error(ctx, "archiving instance flags", zap.Error(err))
+	}
+	members := fsist.Members
+	if err := fsist.Transfer(req.ToSourceId); err != nil {
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "transferring instance claim",
+			zap.Error(err),
+		)
+		return nil, errs.ErrInternalNoSub
+	}
+
+	common.InstancesUDCounter().Add(ctx, -1,
+		metric.WithAttributeSet(common.InstanceAttrs(challengeID, req.FromSourceId, false)),
+	)
+	common.InstancesUDCounter().Add(ctx, 1,
+		metric.WithAttributeSet(common.InstanceAttrs(challengeID, req.ToSourceId, false)),
+	)
+	common.TransfersCounter().Add(ctx, 1,
+		metric.WithAttributes(
+			attribute.String("challenge", challengeID),
+			attribute.String("from", req.FromSourceId),
+			attribute.String("to", req.ToSourceId),
+		),
+	)
+	logger.Info(ctx, "instance transferred",
+		zap.String("from", req.FromSourceId),
+		zap.String("to", req.ToSourceId),
+	)
+
+	// Notify the previous owner and its group they lost the instance, then the new owner
+	ev := events.New(events.Transferred, req.FromSourceId, fsist)
+	ev.Members = members
+	events.Publish(ctx, ev)
+	events.Publish(ctx, events.New(events.Claimed, req.ToSourceId, fsist))
+
+	// 7. Unlock RW instance
+	//    -> defered after 5 (fault-tolerance)
+	// 8. Unlock RW challenge
+	//    -> defered after 2 (fault-tolerance)
+
+	return fromOwnedFS(fsist, req.ToSourceId), nil
+}
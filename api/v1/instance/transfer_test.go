@@ -0,0 +1,84 @@
+package instance
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ctfer-io/chall-manager/global"
+	errs "github.com/ctfer-io/chall-manager/pkg/errors"
+	"github.com/ctfer-io/chall-manager/pkg/events"
+	"github.com/ctfer-io/chall-manager/pkg/fs"
+)
+
+func Test_U_TransferInstance(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	global.Conf.Directory = t.TempDir()
+	require.NoError(os.Mkdir(filepath.Join(global.Conf.Directory, "chall"), os.ModePerm))
+
+	fschall := &fs.Challenge{ID: "chall", Shared: true}
+	require.NoError(fschall.Save())
+	claim := func(identity, sourceID string, members ...string) {
+		fsist := &fs.Instance{ChallengeID: "chall", Identity: identity}
+		require.NoError(fsist.Save())
+		require.NoError(fsist.Claim(sourceID))
+		for _, member := range members {
+			require.NoError(fsist.AddMember(member))
+		}
+	}
+	claim("identity-1", "team-1", "player-1")
+	claim("identity-2", "team-2", "player-2")
+
+	man := &Manager{}
+	ctx := context.Background()
+	transfer := func(from, to string) (*Instance, error) {
+		return man.TransferInstance(ctx, &TransferInstanceRequest{
+			ChallengeId:  "chall",
+			FromSourceId: from,
+			ToSourceId:   to,
+		})
+	}
+
+	// Members can't transfer the instance of their group
+	_, err := transfer("player-1", "team-3")
+	assert.IsType(&errs.ErrNotOwner{}, err)
+
+	// The target can't own an instance, nor be a member of a group that does
+	_, err = transfer("team-1", "team-2")
+	assert.IsType(&errs.ErrInstanceExist{}, err)
+	_, err = transfer("team-1", "player-2")
+	assert.IsType(&errs.ErrInstanceExist{}, err)
+
+	// Once transferred, the members of the previous group are removed
+	sctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	evs, err := events.Subscribe(sctx, "chall")
+	require.NoError(err)
+
+	ist, err := transfer("team-1", "team-3")
+	require.NoError(err)
+	assert.Equal("team-3", ist.SourceId)
+	assert.Empty(ist.Members)
+
+	// The previous owner and its group are notified, then the new owner
+	ev := <-evs
+	assert.Equal(events.Transferred, ev.Type)
+	assert.True(ev.Concerns("player-1"))
+	ev = <-evs
+	assert.Equal(events.Claimed, ev.Type)
+	assert.True(ev.Concerns("team-3"))
+	assert.False(ev.Concerns("player-1"))
+
+	id, owner, err := fs.ResolveInstance("chall", "team-3")
+	require.NoError(err)
+	assert.Equal("identity-1", id)
+	assert.Equal("team-3", owner)
+	_, _, err = fs.ResolveInstance("chall", "player-1")
+	assert.IsType(&errs.ErrInstanceExist{}, err)
+}
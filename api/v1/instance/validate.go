@@ -0,0 +1,161 @@
+package instance
+
+import (
+	"context"
+	"slices"
+
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+
+	"github.com/ctfer-io/chall-manager/api/v1/common"
+	"github.com/ctfer-io/chall-manager/global"
+	errs "github.com/ctfer-io/chall-manager/pkg/errors"
+	"github.com/ctfer-io/chall-manager/pkg/fs"
+	"github.com/ctfer-io/chall-manager/pkg/lock"
+)
+
+func (man *Manager) ValidateFlag(ctx context.Context, req *ValidateFlagRequest) (*ValidateFlagResponse, error) {
+	logger := global.Log()
+	ctx = global.WithChallengeID(ctx, req.ChallengeId)
+	ctx = global.WithSourceID(ctx, req.SourceId)
+	span := trace.SpanFromContext(ctx)
+
+	// 1. Lock R TOTW
+	span.AddEvent("lock TOTW")
+	totw, err := common.LockTOTW(ctx)
+	if err != nil {
+		if totw.IsCanceled(err) {
+			return nil, nil
+		}
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "build TOTW lock", zap.Error(err))
+		return nil, errs.ErrInternalNoSub
+	}
+	if err := totw.RLock(ctx); err != nil {
+		if totw.IsCanceled(err) {
+			return nil, nil
+		}
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "TOTW R lock", zap.Error(err))
+		return nil, errs.ErrInternalNoSub
+	}
+	span.AddEvent("locked TOTW")
+
+	// 2. Lock R challenge
+	clock, err := common.LockChallenge(ctx, req.ChallengeId)
+	if err != nil {
+		if clock.IsCanceled(err) {
+			// If canceled, we need to recover
+			if err := totw.RUnlock(context.WithoutCancel(ctx)); err != nil {
+				err := &errs.ErrInternal{Sub: err}
+				logger.Error(ctx, "recovering from build challenge lock", zap.Error(err))
+				return nil, errs.ErrInternalNoSub
+			}
+			return nil, nil // recovery is successful, we can quit safely
+		}
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "build challenge lock", zap.Error(multierr.Combine(
+			totw.RUnlock(context.WithoutCancel(ctx)),
+			err,
+		)))
+		return nil, errs.ErrInternalNoSub
+	}
+	if err := clock.RLock(ctx); err != nil {
+		if clock.IsCanceled(err) {
+			// If canceled, we need to recover
+			if err := totw.RUnlock(context.WithoutCancel(ctx)); err != nil {
+				err := &errs.ErrInternal{Sub: err}
+				logger.Error(ctx, "recovering from challenge R lock", zap.Error(err))
+				return nil, errs.ErrInternalNoSub
+			}
+			return nil, nil // recovery is successful, we can quit safely
+		}
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "challenge R lock", zap.Error(multierr.Combine(
+			totw.RUnlock(context.WithoutCancel(ctx)),
+			err,
+		)))
+		return nil, errs.ErrInternalNoSub
+	}
+	defer func(lock lock.RWLock) {
+		if err := lock.RUnlock(context.WithoutCancel(ctx)); err != nil {
+			err := &errs.ErrInternal{Sub: err}
+			logger.Error(ctx, "challenge R unlock", zap.Error(err))
+		}
+	}(clock)
+
+	// 3. Unlock R TOTW
+	if err := totw.RUnlock(context.WithoutCancel(ctx)); err != nil {
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "TOTW R unlock", zap.Error(err))
+		return nil, errs.ErrInternalNoSub
+	}
+	span.AddEvent("unlocked TOTW")
+
+	// 4. If challenge does not exist, return error
+	if err := fs.CheckChallenge(req.ChallengeId); err != nil {
+		return nil, err
+	}
+
+	// 5. Look for the flag in the claimed instances, then in the history of
+	//    issued flags
+	claimed := true
+	ists, err := fs.FilterInstances(req.ChallengeId, &fs.InstanceFilter{
+		Claimed: &claimed,
+	})
+	if err != nil {
+		logger.Error(ctx, "listing instances", zap.Error(err))
+		return nil, errs.ErrInternalNoSub
+	}
+	recs, err := fs.LoadFlagHistory(req.ChallengeId)
+	if err != nil {
+		logger.Error(ctx, "loading flag history", zap.Error(err))
+		return nil, errs.ErrInternalNoSub
+	}
+	res := validateFlag(req.SourceId, req.Flag, ists, recs)
+	if res.Verdict == FlagVerdict_shared {
+		logger.Warn(ctx, "shared flag submitted",
+			zap.String("owner", res.GetOwnerSourceId()),
+		)
+	}
+
+	// 6. Unlock R challenge
+	//    -> defered after 2 (fault-tolerance)
+
+	return res, nil
+}
+
+// validateFlag returns the verdict of a flag submitted by the source.
+// The flags issued to the source are checked first, such that a flag that
+// is the same for multiple sources (e.g. not variated) is not considered shared.
+func validateFlag(sourceID, flag string, ists []*fs.ClaimedInstance, recs []*fs.FlagRecord) *ValidateFlagResponse {
+	for _, ist := range ists {
+		if (ist.SourceID == sourceID || slices.Contains(ist.Members, sourceID)) && slices.Contains(ist.Flags, flag) {
+			return &ValidateFlagResponse{Verdict: FlagVerdict_valid}
+		}
+	}
+	for _, rec := range recs {
+		if rec.IssuedTo(sourceID) && slices.Contains(rec.Flags, flag) {
+			return &ValidateFlagResponse{Verdict: FlagVerdict_valid}
+		}
+	}
+
+	for _, ist := range ists {
+		if slices.Contains(ist.Flags, flag) {
+			return &ValidateFlagResponse{
+				Verdict:       FlagVerdict_shared,
+				OwnerSourceId: &ist.SourceID,
+			}
+		}
+	}
+	for _, rec := range recs {
+		if slices.Contains(rec.Flags, flag) {
+			return &ValidateFlagResponse{
+				Verdict:       FlagVerdict_shared,
+				OwnerSourceId: &rec.SourceID,
+			}
+		}
+	}
+	return &ValidateFlagResponse{Verdict: FlagVerdict_invalid}
+}
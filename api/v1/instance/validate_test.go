@@ -0,0 +1,84 @@
+package instance
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ctfer-io/chall-manager/pkg/fs"
+)
+
+func Test_U_ValidateFlag(t *testing.T) {
+	t.Parallel()
+
+	ists := []*fs.ClaimedInstance{
+		{
+			Instance: &fs.Instance{Flags: []string{"FLAG{a}", "FLAG{static}"}},
+			SourceID: "a",
+		}, {
+			Instance: &fs.Instance{Flags: []string{"FLAG{b}", "FLAG{static}"}, Members: []string{"b-1"}},
+			SourceID: "b",
+		},
+	}
+	recs := []*fs.FlagRecord{
+		{SourceID: "c", Flags: []string{"FLAG{c}"}},
+		{SourceID: "a", Flags: []string{"FLAG{a-old}"}},
+	}
+
+	var tests = map[string]struct {
+		SourceID        string
+		Flag            string
+		ExpectedVerdict FlagVerdict
+		ExpectedOwner   string
+	}{
+		"own-instance": {
+			SourceID:        "a",
+			Flag:            "FLAG{a}",
+			ExpectedVerdict: FlagVerdict_valid,
+		},
+		"group-member": {
+			SourceID:        "b-1",
+			Flag:            "FLAG{b}",
+			ExpectedVerdict: FlagVerdict_valid,
+		},
+		"own-history": {
+			SourceID:        "a",
+			Flag:            "FLAG{a-old}",
+			ExpectedVerdict: FlagVerdict_valid,
+		},
+		"not-variated": {
+			SourceID:        "b",
+			Flag:            "FLAG{static}",
+			ExpectedVerdict: FlagVerdict_valid,
+		},
+		"shared-instance": {
+			SourceID:        "c",
+			Flag:            "FLAG{a}",
+			ExpectedVerdict: FlagVerdict_shared,
+			ExpectedOwner:   "a",
+		},
+		"shared-history": {
+			SourceID:        "b",
+			Flag:            "FLAG{c}",
+			ExpectedVerdict: FlagVerdict_shared,
+			ExpectedOwner:   "c",
+		},
+		"invalid": {
+			SourceID:        "a",
+			Flag:            "FLAG{nope}",
+			ExpectedVerdict: FlagVerdict_invalid,
+		},
+	}
+
+	for testname, tt := range tests {
+		t.Run(testname, func(t *testing.T) {
+			t.Parallel()
+
+			assert := assert.New(t)
+
+			res := validateFlag(tt.SourceID, tt.Flag, ists, recs)
+			assert.Equal(tt.ExpectedVerdict, res.Verdict)
+			assert.Equal(tt.ExpectedOwner, res.GetOwnerSourceId())
+		})
+	}
+}
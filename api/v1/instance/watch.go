@@ -0,0 +1,55 @@
+package instance
+
+import (
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+
+	"github.com/ctfer-io/chall-manager/global"
+	errs "github.com/ctfer-io/chall-manager/pkg/errors"
+	"github.com/ctfer-io/chall-manager/pkg/events"
+	"github.com/ctfer-io/chall-manager/pkg/fs"
+)
+
+func (man *Manager) WatchInstance(req *WatchInstanceRequest, server InstanceManager_WatchInstanceServer) error {
+	return watch(req.ChallengeId, req.SourceId, server)
+}
+
+func (man *Manager) WatchChallengeInstances(req *WatchChallengeInstancesRequest, server InstanceManager_WatchChallengeInstancesServer) error {
+	return watch(req.ChallengeId, "", server)
+}
+
+// watch streams the events of the challenge instances until the client leaves.
+// If sourceID is empty, all instances events are streamed.
+func watch(challengeID, sourceID string, server grpc.ServerStreamingServer[InstanceEvent]) error {
+	logger := global.Log()
+	ctx := global.WithChallengeID(server.Context(), challengeID)
+	if sourceID != "" {
+		ctx = global.WithSourceID(ctx, sourceID)
+	}
+
+	// 1. Subscribe first, so no event is missed while checking the challenge exist
+	evs, err := events.Subscribe(ctx, challengeID)
+	if err != nil {
+		err := &errs.ErrInternal{Sub: err}
+		logger.Error(ctx, "subscribing to instance events", zap.Error(err))
+		return errs.ErrInternalNoSub
+	}
+
+	// 2. Check the challenge exist
+	if err := fs.CheckChallenge(challengeID); err != nil {
+		return err
+	}
+
+	logger.Info(ctx, "watching instance events")
+
+	// 3. Stream events until the subscription ends i.e. the client left
+	for ev := range evs {
+		if sourceID != "" && !ev.Concerns(sourceID) {
+			continue
+		}
+		if err := server.Send(fromEvent(ev)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
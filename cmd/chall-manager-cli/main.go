@@ -15,6 +15,7 @@ import (
 	"github.com/urfave/cli/v3"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/durationpb"
 	"google.golang.org/protobuf/types/known/fieldmaskpb"
 	"google.golang.org/protobuf/types/known/timestamppb"
@@ -96,6 +97,21 @@ func main() {
 								Name:  "max",
 								Value: 0,
 							},
+							&cli.StringSliceFlag{
+								Name:  "label",
+								Usage: "A key=value label to select the challenge by, e.g. category=web.",
+							},
+							&cli.TimestampFlag{
+								Name:  "since",
+								Usage: "The date before which no instance can be created, though the pool is provisioned ahead.",
+								Config: cli.TimestampConfig{
+									Layouts: []string{"02-01-2006", time.RFC3339},
+								},
+							},
+							&cli.BoolFlag{
+								Name:  "maintenance",
+								Usage: "If turned on, no new instance can be created.",
+							},
 						},
 						Action: func(ctx context.Context, cmd *cli.Command) error {
 							cliChall := ctx.Value(cliChallKey{}).(challenge.ChallengeStoreClient)
@@ -117,6 +133,15 @@ func main() {
 								}
 							}
 
+							var since *timestamppb.Timestamp
+							if cmd.IsSet("since") {
+								since = timestamppb.New(cmd.Timestamp("since"))
+							}
+							var lbls map[string]string
+							if cmd.IsSet("label") {
+								lbls = toMap(cmd.StringSlice("label"))
+							}
+
 							username := cmd.String("username")
 							password := cmd.String("password")
 
@@ -139,6 +164,10 @@ func main() {
 								Additional: add,
 								Min:        cmd.Int64("min"),
 								Max:        cmd.Int64("max"),
+								Labels:     lbls,
+
+								Since:       since,
+								Maintenance: cmd.Bool("maintenance"),
 							}, grpc.MaxCallSendMsgSize(math.MaxInt64))
 							if err != nil {
 								return err
@@ -176,8 +205,11 @@ func main() {
 						Name: "update",
 						Flags: []cli.Flag{
 							&cli.StringFlag{
-								Name:     "id",
-								Required: true,
+								Name: "id",
+							},
+							&cli.StringFlag{
+								Name:  "selector",
+								Usage: "A label selector to update all the matching challenges rather than one, e.g. category=web.",
 							},
 							&cli.StringFlag{
 								Name: "scenario",
@@ -219,12 +251,33 @@ func main() {
 							&cli.BoolFlag{
 								Name: "reset-additional",
 							},
+							&cli.StringSliceFlag{
+								Name:  "label",
+								Usage: "A key=value label to select the challenge by, e.g. category=web.",
+							},
+							&cli.BoolFlag{
+								Name: "reset-labels",
+							},
+							&cli.TimestampFlag{
+								Name:  "since",
+								Usage: "The date before which no instance can be created, though the pool is provisioned ahead.",
+								Config: cli.TimestampConfig{
+									Layouts: []string{"02-01-2006", time.RFC3339},
+								},
+							},
+							&cli.BoolFlag{
+								Name: "reset-since",
+							},
+							&cli.BoolFlag{
+								Name:  "maintenance",
+								Usage: "Turns on (or off with --maintenance=false) the maintenance, during which no new instance can be created.",
+							},
 							&cli.StringFlag{
 								Name:  "strategy",
 								Value: "in-place",
 								Action: func(_ context.Context, _ *cli.Command, strategy string) error {
 									switch strategy {
-									case "blue-green", "recreate", "in-place":
+									case "blue-green", "recreate", "in-place", "canary", "deferred":
 										// everything is fine
 										return nil
 									default:
@@ -232,6 +285,34 @@ func main() {
 									}
 								},
 							},
+							&cli.Int64Flag{
+								Name:  "canary-percent",
+								Usage: "With the canary strategy, the percentage of claimed instances to update first.",
+							},
+							&cli.Int64Flag{
+								Name:  "parallelism",
+								Usage: "The maximum number of instances to update at once, 0 means no limit.",
+							},
+							&cli.Int64Flag{
+								Name:  "max-unavailable",
+								Usage: "The maximum number of instances unavailable at once with the recreate strategy, 0 means no limit.",
+							},
+							&cli.StringFlag{
+								Name:  "health-check",
+								Usage: "With the blue-green strategy, the health check new instances must pass (tcp, http or output).",
+								Action: func(_ context.Context, _ *cli.Command, probe string) error {
+									switch probe {
+									case "tcp", "http", "output":
+										return nil
+									default:
+										return fmt.Errorf("unsupported health check: %s", probe)
+									}
+								},
+							},
+							&cli.DurationFlag{
+								Name:  "health-timeout",
+								Usage: "The time new instances have to pass the health check.",
+							},
 							&cli.Int64Flag{
 								Name:  "min",
 								Value: 0,
@@ -243,6 +324,9 @@ func main() {
 						},
 						Action: func(ctx context.Context, cmd *cli.Command) error {
 							cliChall := ctx.Value(cliChallKey{}).(challenge.ChallengeStoreClient)
+							if cmd.IsSet("id") == cmd.IsSet("selector") {
+								return fmt.Errorf("exactly one of --id or --selector is required")
+							}
 
 							ref := cmd.String("scenario")
 							if cmd.IsSet("directory") {
@@ -307,6 +391,32 @@ func main() {
 									return err
 								}
 							}
+							if cmd.IsSet("label") {
+								if err := um.Append(req, "labels"); err != nil {
+									return err
+								}
+								req.Labels = toMap(cmd.StringSlice("label"))
+							} else if cmd.Bool("reset-labels") {
+								if err := um.Append(req, "labels"); err != nil {
+									return err
+								}
+							}
+							if cmd.IsSet("since") {
+								if err := um.Append(req, "since"); err != nil {
+									return err
+								}
+								req.Since = timestamppb.New(cmd.Timestamp("since"))
+							} else if cmd.Bool("reset-since") {
+								if err := um.Append(req, "since"); err != nil {
+									return err
+								}
+							}
+							if cmd.IsSet("maintenance") {
+								if err := um.Append(req, "maintenance"); err != nil {
+									return err
+								}
+								req.Maintenance = cmd.Bool("maintenance")
+							}
 							if cmd.IsSet("min") {
 								if err := um.Append(req, "min"); err != nil {
 									return err
@@ -326,15 +436,60 @@ func main() {
 								req.UpdateStrategy = challenge.UpdateStrategy_recreate.Enum()
 							case "in-place":
 								req.UpdateStrategy = challenge.UpdateStrategy_update_in_place.Enum()
+							case "canary":
+								req.UpdateStrategy = challenge.UpdateStrategy_canary.Enum()
+								req.CanaryPercent = cmd.Int64("canary-percent")
+							case "deferred":
+								req.UpdateStrategy = challenge.UpdateStrategy_deferred.Enum()
+							}
+
+							req.Parallelism = cmd.Int64("parallelism")
+							req.MaxUnavailable = cmd.Int64("max-unavailable")
+							if cmd.IsSet("health-check") {
+								req.HealthCheck = &challenge.HealthCheck{}
+								switch cmd.String("health-check") {
+								case "tcp":
+									req.HealthCheck.Probe = &challenge.HealthCheck_Tcp{Tcp: &challenge.TCPProbe{}}
+								case "http":
+									req.HealthCheck.Probe = &challenge.HealthCheck_Http{Http: &challenge.HTTPProbe{}}
+								case "output":
+									req.HealthCheck.Probe = &challenge.HealthCheck_Output{Output: &challenge.OutputProbe{}}
+								}
+								if cmd.IsSet("health-timeout") {
+									req.HealthCheck.Timeout = durationpb.New(cmd.Duration("health-timeout"))
+								}
 							}
 
 							req.UpdateMask = um
+							if cmd.IsSet("selector") {
+								resp, err := cliChall.BulkUpdateChallenges(ctx, &challenge.BulkUpdateChallengesRequest{
+									LabelSelector: cmd.String("selector"),
+									Update:        req,
+								})
+								if err != nil {
+									return err
+								}
+								for _, res := range resp.Results {
+									if res.Error != nil {
+										fmt.Printf("[!] Challenge %s not updated: %s\n", res.Id, *res.Error)
+										continue
+									}
+									printUpdated(res.Challenge)
+								}
+								return nil
+							}
+
 							chall, err := cliChall.UpdateChallenge(ctx, req)
 							if err != nil {
+								// Aborted updates (e.g. canaries failed) detail the outcome of each instance
+								for _, d := range status.Convert(err).Details() {
+									if chall, ok := d.(*challenge.Challenge); ok {
+										printOutcomes(chall)
+									}
+								}
 								return err
 							}
-
-							fmt.Printf("[~] Challenge %s updated\n", chall.Id)
+							printUpdated(chall)
 
 							return nil
 						},
@@ -342,12 +497,35 @@ func main() {
 						Name: "delete",
 						Flags: []cli.Flag{
 							&cli.StringFlag{
-								Name:     "id",
-								Required: true,
+								Name: "id",
+							},
+							&cli.StringFlag{
+								Name:  "selector",
+								Usage: "A label selector to delete all the matching challenges rather than one, e.g. event=quals.",
 							},
 						},
 						Action: func(ctx context.Context, cmd *cli.Command) error {
 							cliChall := ctx.Value(cliChallKey{}).(challenge.ChallengeStoreClient)
+							if cmd.IsSet("id") == cmd.IsSet("selector") {
+								return fmt.Errorf("exactly one of --id or --selector is required")
+							}
+							if cmd.IsSet("selector") {
+								resp, err := cliChall.BulkDeleteChallenges(ctx, &challenge.BulkDeleteChallengesRequest{
+									LabelSelector: cmd.String("selector"),
+								})
+								if err != nil {
+									return err
+								}
+								for _, res := range resp.Results {
+									if res.Error != nil {
+										fmt.Printf("[!] Challenge %s not deleted: %s\n", res.Id, *res.Error)
+										continue
+									}
+									fmt.Printf("[-] Challenge %s deleted\n", res.Id)
+								}
+								return nil
+							}
+
 							id := cmd.String("id")
 							if _, err := cliChall.DeleteChallenge(ctx, &challenge.DeleteChallengeRequest{
 								Id: id,
@@ -357,6 +535,57 @@ func main() {
 
 							fmt.Printf("[-] Challenge %s deleted\n", id)
 
+							return nil
+						},
+					}, {
+						Name: "revisions",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "id",
+								Required: true,
+							},
+						},
+						Action: func(ctx context.Context, cmd *cli.Command) error {
+							cliChall := ctx.Value(cliChallKey{}).(challenge.ChallengeStoreClient)
+
+							resp, err := cliChall.ListChallengeRevisions(ctx, &challenge.ListChallengeRevisionsRequest{
+								Id: cmd.String("id"),
+							})
+							if err != nil {
+								return err
+							}
+
+							for _, rev := range resp.Revisions {
+								fmt.Printf("%d\t%s\t%s\t%s\n", rev.Revision, rev.At.AsTime().Format(time.RFC3339), rev.UpdateStrategy, rev.Scenario)
+							}
+
+							return nil
+						},
+					}, {
+						Name: "rollback",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "id",
+								Required: true,
+							},
+							&cli.Int64Flag{
+								Name:     "revision",
+								Required: true,
+							},
+						},
+						Action: func(ctx context.Context, cmd *cli.Command) error {
+							cliChall := ctx.Value(cliChallKey{}).(challenge.ChallengeStoreClient)
+
+							chall, err := cliChall.RollbackChallenge(ctx, &challenge.RollbackChallengeRequest{
+								Id:       cmd.String("id"),
+								Revision: cmd.Int64("revision"),
+							})
+							if err != nil {
+								return err
+							}
+
+							fmt.Printf("[~] Challenge %s rolled back to revision %d\n", chall.Id, cmd.Int64("revision"))
+
 							return nil
 						},
 					},
@@ -446,6 +675,250 @@ func main() {
 
 							fmt.Printf("[+] Instance <%s,%s> deleted\n", cmd.String("challenge_id"), cmd.String("source_id"))
 
+							return nil
+						},
+					}, {
+						Name: "reset",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "challenge_id",
+								Required: true,
+							},
+							&cli.StringFlag{
+								Name:     "source_id",
+								Required: true,
+							},
+							&cli.BoolFlag{
+								Name:  "new-identity",
+								Usage: "If set, the instance is reset with a new identity (e.g. its flags change).",
+							},
+						},
+						Action: func(ctx context.Context, cmd *cli.Command) error {
+							cliIst := ctx.Value(cliIstKey{}).(instance.InstanceManagerClient)
+
+							before := time.Now()
+							ist, err := cliIst.ResetInstance(ctx, &instance.ResetInstanceRequest{
+								ChallengeId: cmd.String("challenge_id"),
+								SourceId:    cmd.String("source_id"),
+								NewIdentity: cmd.Bool("new-identity"),
+							})
+							fmt.Printf("duration: %s\n", time.Since(before))
+							if err != nil {
+								return err
+							}
+
+							fmt.Printf(
+								"[+] Instance <%s,%s> reset, connect with `%s`\n",
+								ist.ChallengeId,
+								ist.SourceId,
+								ist.ConnectionInfo,
+							)
+
+							return nil
+						},
+					}, {
+						Name:  "validate",
+						Usage: "Validate a flag submitted by a source.",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "challenge_id",
+								Required: true,
+							},
+							&cli.StringFlag{
+								Name:     "source_id",
+								Required: true,
+							},
+							&cli.StringFlag{
+								Name:     "flag",
+								Required: true,
+							},
+						},
+						Action: func(ctx context.Context, cmd *cli.Command) error {
+							cliIst := ctx.Value(cliIstKey{}).(instance.InstanceManagerClient)
+
+							res, err := cliIst.ValidateFlag(ctx, &instance.ValidateFlagRequest{
+								ChallengeId: cmd.String("challenge_id"),
+								SourceId:    cmd.String("source_id"),
+								Flag:        cmd.String("flag"),
+							})
+							if err != nil {
+								return err
+							}
+
+							if res.OwnerSourceId != nil {
+								fmt.Printf("[~] Flag is %s, issued to %s\n", res.Verdict, *res.OwnerSourceId)
+								return nil
+							}
+							fmt.Printf("[+] Flag is %s\n", res.Verdict)
+
+							return nil
+						},
+					}, {
+						Name:  "transfer",
+						Usage: "Transfer an instance from a source to another.",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "challenge_id",
+								Required: true,
+							},
+							&cli.StringFlag{
+								Name:     "from",
+								Required: true,
+							},
+							&cli.StringFlag{
+								Name:     "to",
+								Required: true,
+							},
+						},
+						Action: func(ctx context.Context, cmd *cli.Command) error {
+							cliIst := ctx.Value(cliIstKey{}).(instance.InstanceManagerClient)
+
+							if _, err := cliIst.TransferInstance(ctx, &instance.TransferInstanceRequest{
+								ChallengeId:  cmd.String("challenge_id"),
+								FromSourceId: cmd.String("from"),
+								ToSourceId:   cmd.String("to"),
+							}); err != nil {
+								return err
+							}
+
+							fmt.Printf("[+] Instance of challenge %s transferred from %s to %s\n", cmd.String("challenge_id"), cmd.String("from"), cmd.String("to"))
+
+							return nil
+						},
+					}, {
+						Name:  "cancel",
+						Usage: "Cancel the in-flight operation on an instance, e.g. its provisioning.",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "challenge_id",
+								Required: true,
+							},
+							&cli.StringFlag{
+								Name:     "source_id",
+								Required: true,
+							},
+						},
+						Action: func(ctx context.Context, cmd *cli.Command) error {
+							cliIst := ctx.Value(cliIstKey{}).(instance.InstanceManagerClient)
+
+							if _, err := cliIst.CancelInstanceOperation(ctx, &instance.CancelInstanceOperationRequest{
+								ChallengeId: cmd.String("challenge_id"),
+								SourceId:    cmd.String("source_id"),
+							}); err != nil {
+								return err
+							}
+
+							fmt.Printf("[+] Operation on instance of challenge %s for source %s canceled\n", cmd.String("challenge_id"), cmd.String("source_id"))
+
+							return nil
+						},
+					}, {
+						Name:  "refresh",
+						Usage: "Refresh an instance to detect drift from its state, and repair it if requested.",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "challenge_id",
+								Required: true,
+							},
+							&cli.StringFlag{
+								Name:     "source_id",
+								Required: true,
+							},
+							&cli.BoolFlag{
+								Name:  "repair",
+								Usage: "Repair the instance if it drifted.",
+							},
+						},
+						Action: func(ctx context.Context, cmd *cli.Command) error {
+							cliIst := ctx.Value(cliIstKey{}).(instance.InstanceManagerClient)
+
+							drift, err := cliIst.RefreshInstance(ctx, &instance.RefreshInstanceRequest{
+								ChallengeId: cmd.String("challenge_id"),
+								SourceId:    cmd.String("source_id"),
+								Repair:      cmd.Bool("repair"),
+							})
+							if err != nil {
+								return err
+							}
+							if drift.Error != nil {
+								return fmt.Errorf("refreshing instance: %s", drift.GetError())
+							}
+
+							if !drift.Drifted {
+								fmt.Printf("[+] Instance has not drifted (%d resources unchanged)\n", drift.Sames)
+								return nil
+							}
+							fmt.Printf("[~] Instance drifted: %d resources changed, %d deleted\n", drift.Updates, drift.Deletes)
+							if drift.Repaired {
+								fmt.Println("[+] Instance repaired")
+							}
+
+							return nil
+						},
+					}, {
+						Name:  "add-member",
+						Usage: "Add a member to the shared instance of a group.",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "challenge_id",
+								Required: true,
+							},
+							&cli.StringFlag{
+								Name:     "source_id",
+								Required: true,
+							},
+							&cli.StringFlag{
+								Name:     "member_id",
+								Required: true,
+							},
+						},
+						Action: func(ctx context.Context, cmd *cli.Command) error {
+							cliIst := ctx.Value(cliIstKey{}).(instance.InstanceManagerClient)
+
+							ist, err := cliIst.AddInstanceMember(ctx, &instance.AddInstanceMemberRequest{
+								ChallengeId: cmd.String("challenge_id"),
+								SourceId:    cmd.String("source_id"),
+								MemberId:    cmd.String("member_id"),
+							})
+							if err != nil {
+								return err
+							}
+
+							fmt.Printf("[+] Instance <%s,%s> members: %v\n", ist.ChallengeId, ist.SourceId, ist.Members)
+
+							return nil
+						},
+					}, {
+						Name:  "remove-member",
+						Usage: "Remove a member from the shared instance of a group.",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "challenge_id",
+								Required: true,
+							},
+							&cli.StringFlag{
+								Name:     "source_id",
+								Required: true,
+							},
+							&cli.StringFlag{
+								Name:     "member_id",
+								Required: true,
+							},
+						},
+						Action: func(ctx context.Context, cmd *cli.Command) error {
+							cliIst := ctx.Value(cliIstKey{}).(instance.InstanceManagerClient)
+
+							ist, err := cliIst.RemoveInstanceMember(ctx, &instance.RemoveInstanceMemberRequest{
+								ChallengeId: cmd.String("challenge_id"),
+								SourceId:    cmd.String("source_id"),
+								MemberId:    cmd.String("member_id"),
+							})
+							if err != nil {
+								return err
+							}
+
+							fmt.Printf("[+] Instance <%s,%s> members: %v\n", ist.ChallengeId, ist.SourceId, ist.Members)
+
 							return nil
 						},
 					},
@@ -507,3 +980,28 @@ func main() {
 func ptr[T any](t T) *T {
 	return &t
 }
+
+// toMap parses key=value pairs.
+func toMap(slc []string) map[string]string {
+	m := make(map[string]string, len(slc))
+	for _, kv := range slc {
+		k, v, _ := strings.Cut(kv, "=")
+		m[k] = v
+	}
+	return m
+}
+
+func printUpdated(chall *challenge.Challenge) {
+	fmt.Printf("[~] Challenge %s updated\n", chall.Id)
+	printOutcomes(chall)
+}
+
+func printOutcomes(chall *challenge.Challenge) {
+	for _, upd := range chall.Updates {
+		if upd.Error != nil {
+			fmt.Printf("    %s: %s (%s)\n", upd.Identity, upd.Outcome, *upd.Error)
+			continue
+		}
+		fmt.Printf("    %s: %s\n", upd.Identity, upd.Outcome)
+	}
+}
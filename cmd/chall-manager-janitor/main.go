@@ -47,8 +47,14 @@ var (
 
 	tracing     bool
 	serviceName string
+	drift       string
 
-	cb *gobreaker.CircuitBreaker[grpc.ServerStreamingClient[challenge.Challenge]]
+	cb *gobreaker.CircuitBreaker[grpc.ServerStreamingClient[instance.Instance]]
+)
+
+const (
+	driftDetect = "detect"
+	driftRepair = "repair"
 )
 
 func main() {
@@ -98,6 +104,20 @@ func main() {
 				// Not recommended because the janitor was not made to be a long-running software.
 				// It was not optimised in this way, despite it should work fine.
 			},
+			&cli.StringFlag{
+				Name:    "drift",
+				Sources: cli.EnvVars("DRIFT"),
+				Usage: `If set, sweeps the challenges instances to detect drift from their state. ` +
+					`Use "detect" to only report it, or "repair" to converge drifted instances back to their scenario.`,
+				Action: func(_ context.Context, _ *cli.Command, mode string) error {
+					switch mode {
+					case driftDetect, driftRepair:
+						return nil
+					}
+					return fmt.Errorf("invalid drift mode %s, should be %s or %s", mode, driftDetect, driftRepair)
+				},
+				Destination: &drift,
+			},
 			&cli.IntFlag{
 				Name:     "max-requests",
 				Category: "resiliency",
@@ -177,7 +197,7 @@ func run(ctx context.Context, cmd *cli.Command) error {
 	logger := Log()
 
 	// Setup the circuit breaker to chall-manager
-	cb = gobreaker.NewCircuitBreaker[grpc.ServerStreamingClient[challenge.Challenge]](gobreaker.Settings{
+	cb = gobreaker.NewCircuitBreaker[grpc.ServerStreamingClient[instance.Instance]](gobreaker.Settings{
 		Name:        "chall-manager",
 		MaxRequests: uint32(cmd.Int("max-requests")), //nolint:gosec
 		Interval:    cmd.Duration("interval"),
@@ -250,14 +270,18 @@ func janitor(ctx context.Context, cli *grpc.ClientConn) error {
 	logger := Log()
 	logger.Info(ctx, "starting janitoring")
 
-	store := challenge.NewChallengeStoreClient(cli)
 	manager := instance.NewInstanceManagerClient(cli)
 
 	span := trace.SpanFromContext(ctx)
-	span.AddEvent("querying challenges")
-
-	challs, err := cb.Execute(func() (grpc.ServerStreamingClient[challenge.Challenge], error) {
-		return store.QueryChallenge(ctx, nil)
+	span.AddEvent("querying expired instances")
+
+	// Only query expired instances, challenges with no dates configured have none
+	claimed := true
+	ists, err := cb.Execute(func() (grpc.ServerStreamingClient[instance.Instance], error) {
+		return manager.QueryInstance(ctx, &instance.QueryInstanceRequest{
+			Claimed: &claimed,
+			Expired: true,
+		})
 	})
 	if err != nil {
 		if errors.Is(err, gobreaker.ErrOpenState) || errors.Is(err, gobreaker.ErrTooManyRequests) {
@@ -269,49 +293,127 @@ func janitor(ctx context.Context, cli *grpc.ClientConn) error {
 		}
 		return err
 	}
+
+	// Janitor outdated instances
+	wg := &sync.WaitGroup{}
 	for {
-		chall, err := challs.Recv()
+		ist, err := ists.Recv()
 		if err != nil {
 			if err == io.EOF {
 				break
 			}
+			wg.Wait()
 			return err
 		}
-		ctx := WithChallengeID(ctx, chall.Id)
+		ctx := WithChallengeID(ctx, ist.ChallengeId)
+		ctx = WithSourceID(ctx, ist.SourceId)
+
+		logger.Info(ctx, "janitoring instance")
+		wg.Add(1)
+
+		go func(ist *instance.Instance) {
+			defer wg.Done()
+
+			if _, err := manager.DeleteInstance(ctx, &instance.DeleteInstanceRequest{
+				ChallengeId: ist.ChallengeId,
+				SourceId:    ist.SourceId,
+			}); err != nil {
+				logger.Error(ctx, "deleting challenge instance",
+					zap.Error(err),
+				)
+			}
+		}(ist)
+	}
+	wg.Wait()
 
-		// Don't janitor if the challenge has no dates configured
-		if chall.Timeout == nil && chall.Until == nil {
-			logger.Info(ctx, "skipping challenge with no dates configured")
-			continue
+	if drift != "" {
+		if err := sweep(ctx, challenge.NewChallengeStoreClient(cli), manager); err != nil {
+			return err
 		}
+	}
+
+	logger.Info(ctx, "completed janitoring")
 
-		// Janitor outdated instances
-		wg := &sync.WaitGroup{}
-		for _, ist := range chall.Instances {
-			ctx := WithSourceID(ctx, ist.SourceId)
+	return nil
+}
 
-			if time.Now().After(ist.Until.AsTime()) {
-				logger.Info(ctx, "janitoring instance")
-				wg.Add(1)
+// sweep refreshes the instances of all challenges, to detect (and repair if
+// configured) drift from their state.
+// The circuit breaker is not involved, as it already let this janitoring run.
+func sweep(ctx context.Context, store challenge.ChallengeStoreClient, manager instance.InstanceManagerClient) error {
+	logger := Log()
+
+	span := trace.SpanFromContext(ctx)
+	span.AddEvent("querying challenges to sweep")
 
-				go func(ist *instance.Instance) {
-					defer wg.Done()
+	// Only the challenges identifiers are needed, not their instances
+	challs, err := store.QueryChallenge(ctx, &challenge.QueryChallengeRequest{
+		OmitInstances: true,
+	})
+	if err != nil {
+		return err
+	}
+	challIDs := []string{}
+	for {
+		chall, err := challs.Recv()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+		challIDs = append(challIDs, chall.Id)
+	}
 
-					if _, err := manager.DeleteInstance(ctx, &instance.DeleteInstanceRequest{
-						ChallengeId: ist.ChallengeId,
-						SourceId:    ist.SourceId,
-					}); err != nil {
-						logger.Error(ctx, "deleting challenge instance",
+	wg := &sync.WaitGroup{}
+	for _, challID := range challIDs {
+		ctx := WithChallengeID(ctx, challID)
+
+		logger.Info(ctx, "sweeping challenge instances")
+		wg.Add(1)
+
+		go func(challID string) {
+			defer wg.Done()
+
+			drifts, err := manager.RefreshChallengeInstances(ctx, &instance.RefreshChallengeInstancesRequest{
+				ChallengeId: challID,
+				Repair:      drift == driftRepair,
+			})
+			if err != nil {
+				logger.Error(ctx, "refreshing challenge instances",
+					zap.Error(err),
+				)
+				return
+			}
+			for {
+				d, err := drifts.Recv()
+				if err != nil {
+					if err != io.EOF {
+						logger.Error(ctx, "refreshing challenge instances",
 							zap.Error(err),
 						)
 					}
-				}(ist)
+					return
+				}
+				if d.Error != nil {
+					logger.Error(ctx, "refreshing challenge instance",
+						zap.String("identity", d.Identity),
+						zap.String("error", d.GetError()),
+					)
+					continue
+				}
+				if d.Drifted {
+					logger.Info(ctx, "challenge instance drifted",
+						zap.String("identity", d.Identity),
+						zap.Int64("updates", d.Updates),
+						zap.Int64("deletes", d.Deletes),
+						zap.Bool("repaired", d.Repaired),
+					)
+				}
 			}
-		}
-		wg.Wait()
+		}(challID)
 	}
-
-	logger.Info(ctx, "completed janitoring")
+	wg.Wait()
 
 	return nil
 }
@@ -7,6 +7,7 @@ import (
 	"os/signal"
 	"path/filepath"
 	"syscall"
+	"time"
 
 	"github.com/ctfer-io/chall-manager/global"
 	"github.com/ctfer-io/chall-manager/server"
@@ -76,6 +77,92 @@ func main() {
 				Destination: &global.Conf.LogLevel,
 				Usage:       "Use to specify the level of logging.",
 			},
+			&cli.Int64Flag{
+				Name:        "max-instances-per-source",
+				Sources:     cli.EnvVars("MAX_INSTANCES_PER_SOURCE"),
+				Category:    "global",
+				Destination: &global.Conf.MaxInstancesPerSource,
+				Usage: "Define the maximum number of instances a source can have at once over all challenges. " +
+					"Can be overridden per challenge. Default to no limit.",
+				Action: func(_ context.Context, _ *cli.Command, n int64) error {
+					if n < 0 {
+						return errors.New("max instances per source must be positive")
+					}
+					return nil
+				},
+			},
+			&cli.Int64Flag{
+				Name:        "flag-history",
+				Sources:     cli.EnvVars("FLAG_HISTORY"),
+				Category:    "global",
+				Value:       1024,
+				Destination: &global.Conf.FlagHistory,
+				Usage: "Define the number of flags records kept per challenge once their instance no longer holds them " +
+					"(e.g. deleted), in order to detect shared flags. 0 disables the history.",
+				Action: func(_ context.Context, _ *cli.Command, n int64) error {
+					if n < 0 {
+						return errors.New("flag history must be positive")
+					}
+					return nil
+				},
+			},
+			&cli.DurationFlag{
+				Name:        "idempotency-window",
+				Sources:     cli.EnvVars("IDEMPOTENCY_WINDOW"),
+				Category:    "global",
+				Value:       10 * time.Minute,
+				Destination: &global.Conf.IdempotencyWindow,
+				Usage: "Define the time the responses of requests carrying an idempotency key are kept, such that " +
+					"a retry returns the original response rather than running again. 0 disables idempotency.",
+				Action: func(_ context.Context, _ *cli.Command, d time.Duration) error {
+					if d < 0 {
+						return errors.New("idempotency window must be positive")
+					}
+					return nil
+				},
+			},
+			&cli.Int64Flag{
+				Name:        "up-retries",
+				Sources:     cli.EnvVars("UP_RETRIES"),
+				Category:    "scenario",
+				Destination: &global.Conf.UpRetries,
+				Usage: "Define the number of times a failed stack up is retried, before the resources it managed " +
+					"to create are destroyed. Default to no retry.",
+				Action: func(_ context.Context, _ *cli.Command, n int64) error {
+					if n < 0 {
+						return errors.New("up retries must be positive")
+					}
+					return nil
+				},
+			},
+			&cli.DurationFlag{
+				Name:        "up-backoff",
+				Sources:     cli.EnvVars("UP_BACKOFF"),
+				Category:    "scenario",
+				Value:       5 * time.Second,
+				Destination: &global.Conf.UpBackoff,
+				Usage:       "Define the wait before retrying a failed stack up, doubled at each retry.",
+				Action: func(_ context.Context, _ *cli.Command, d time.Duration) error {
+					if d < 0 {
+						return errors.New("up backoff must be positive")
+					}
+					return nil
+				},
+			},
+			&cli.Int64Flag{
+				Name:        "max-concurrent-stacks",
+				Sources:     cli.EnvVars("MAX_CONCURRENT_STACKS"),
+				Category:    "scenario",
+				Destination: &global.Conf.MaxConcurrentStacks,
+				Usage: "Define the maximum number of stack operations running at once, others being queued. " +
+					"Player-triggered creations are run first, then pool refills and updates. Default to no limit.",
+				Action: func(_ context.Context, _ *cli.Command, n int64) error {
+					if n < 0 {
+						return errors.New("max concurrent stacks must be positive")
+					}
+					return nil
+				},
+			},
 			&cli.BoolFlag{
 				Name:        "tracing",
 				Sources:     cli.EnvVars("TRACING"),
@@ -1,5 +1,7 @@
 package global
 
+import "time"
+
 var (
 	Version = ""
 )
@@ -10,6 +12,28 @@ type Configuration struct {
 	Cache     string
 	LogLevel  string
 
+	// MaxInstancesPerSource is the maximum number of instances a source can
+	// have at once over all challenges. 0 means no limit.
+	MaxInstancesPerSource int64
+
+	// MaxConcurrentStacks is the maximum number of stack operations (i.e. Pulumi
+	// engines) running at once, loading the stacks included. 0 means no limit.
+	MaxConcurrentStacks int64
+
+	// UpRetries is the number of times a failed stack up is retried before its
+	// partial stack is destroyed. UpBackoff is the wait before the first retry,
+	// doubled at each retry.
+	UpRetries int64
+	UpBackoff time.Duration
+
+	// FlagHistory is the number of flag records kept per challenge once their
+	// instance no longer holds them. 0 disables the history.
+	FlagHistory int64
+
+	// IdempotencyWindow is the time the responses of requests carrying an
+	// idempotency key are kept for retries. 0 disables idempotency.
+	IdempotencyWindow time.Duration
+
 	Otel struct {
 		Tracing     bool
 		ServiceName string
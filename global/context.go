@@ -43,6 +43,12 @@ func WithSourceID(ctx context.Context, id string) context.Context {
 	return context.WithValue(ctx, sourceKey{}, id)
 }
 
+// IdentityFrom returns the instance identity the context holds, if any.
+func IdentityFrom(ctx context.Context) string {
+	id, _ := ctx.Value(identityKey{}).(string)
+	return id
+}
+
 func WithoutChallengeID(ctx context.Context) context.Context {
 	return &withoutCtx{
 		parent:  ctx,
@@ -0,0 +1,7 @@
+/*
+Package errors defines the errors of the chall-manager API.
+
+Errors implementing a GRPCStatus method are returned to the callers with the
+corresponding gRPC code, such that they can be handled accordingly.
+*/
+package errors
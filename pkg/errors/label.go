@@ -0,0 +1,27 @@
+package errors
+
+import (
+	"fmt"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrInvalidLabel is returned when a challenge label is rejected on creation
+// or update, i.e. its key is empty, or its key or value contains characters
+// other than alphanumeric ones, '-', '_', '.' or '/'.
+type ErrInvalidLabel struct {
+	Key    string
+	Value  string
+	Reason string
+}
+
+var _ error = (*ErrInvalidLabel)(nil)
+
+func (err ErrInvalidLabel) Error() string {
+	return fmt.Sprintf("invalid label %s=%s: %s", err.Key, err.Value, err.Reason)
+}
+
+func (err ErrInvalidLabel) GRPCStatus() *status.Status {
+	return status.New(codes.InvalidArgument, err.Error())
+}
@@ -0,0 +1,26 @@
+package errors
+
+import (
+	"fmt"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrNoOperation is returned when there is no in-flight operation to cancel on
+// an instance in this replica. In-flight operations are tracked by replica, so
+// the operation may run in another one.
+type ErrNoOperation struct {
+	ChallengeID string
+	Identity    string
+}
+
+var _ error = (*ErrNoOperation)(nil)
+
+func (err ErrNoOperation) Error() string {
+	return fmt.Sprintf("no operation in progress on instance %s of challenge %s in this replica, it may run in another one", err.Identity, err.ChallengeID)
+}
+
+func (err ErrNoOperation) GRPCStatus() *status.Status {
+	return status.New(codes.FailedPrecondition, err.Error())
+}
@@ -0,0 +1,25 @@
+package errors
+
+import (
+	"fmt"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrNotOwner is returned when a member of a group tries an operation that
+// only the group owning a shared instance can perform.
+type ErrNotOwner struct {
+	ChallengeID string
+	SourceID    string
+}
+
+var _ error = (*ErrNotOwner)(nil)
+
+func (err ErrNotOwner) Error() string {
+	return fmt.Sprintf("source %s does not own the instance of challenge %s", err.SourceID, err.ChallengeID)
+}
+
+func (err ErrNotOwner) GRPCStatus() *status.Status {
+	return status.New(codes.PermissionDenied, err.Error())
+}
@@ -0,0 +1,25 @@
+package errors
+
+import (
+	"fmt"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrQuotaExceeded is returned when a source already has as many instances
+// as it is allowed to have at once.
+type ErrQuotaExceeded struct {
+	SourceID string
+	Max      int64
+}
+
+var _ error = (*ErrQuotaExceeded)(nil)
+
+func (err ErrQuotaExceeded) Error() string {
+	return fmt.Sprintf("source %s reached its quota of %d instances", err.SourceID, err.Max)
+}
+
+func (err ErrQuotaExceeded) GRPCStatus() *status.Status {
+	return status.New(codes.ResourceExhausted, err.Error())
+}
@@ -0,0 +1,25 @@
+package errors
+
+import (
+	"fmt"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrRenewPolicy is returned when an instance can't be renewed anymore
+// due to its challenge renewal policy.
+type ErrRenewPolicy struct {
+	ChallengeID string
+	Reason      string
+}
+
+var _ error = (*ErrRenewPolicy)(nil)
+
+func (err ErrRenewPolicy) Error() string {
+	return fmt.Sprintf("instance of challenge %s can't be renewed: %s", err.ChallengeID, err.Reason)
+}
+
+func (err ErrRenewPolicy) GRPCStatus() *status.Status {
+	return status.New(codes.FailedPrecondition, err.Error())
+}
@@ -0,0 +1,25 @@
+package errors
+
+import (
+	"fmt"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrRevisionNotFound is returned when a challenge revision was never
+// recorded in its history.
+type ErrRevisionNotFound struct {
+	ChallengeID string
+	Revision    int64
+}
+
+var _ error = (*ErrRevisionNotFound)(nil)
+
+func (err ErrRevisionNotFound) Error() string {
+	return fmt.Sprintf("challenge %s has no revision %d", err.ChallengeID, err.Revision)
+}
+
+func (err ErrRevisionNotFound) GRPCStatus() *status.Status {
+	return status.New(codes.NotFound, err.Error())
+}
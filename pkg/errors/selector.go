@@ -0,0 +1,24 @@
+package errors
+
+import (
+	"fmt"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrInvalidSelector is returned when a label selector is malformed.
+type ErrInvalidSelector struct {
+	Selector string
+	Reason   string
+}
+
+var _ error = (*ErrInvalidSelector)(nil)
+
+func (err ErrInvalidSelector) Error() string {
+	return fmt.Sprintf("invalid label selector %q: %s", err.Selector, err.Reason)
+}
+
+func (err ErrInvalidSelector) GRPCStatus() *status.Status {
+	return status.New(codes.InvalidArgument, err.Error())
+}
@@ -0,0 +1,28 @@
+package errors
+
+import (
+	"fmt"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrInstanceStatus is returned when an operation can't be performed on an
+// instance given its lifecycle status, e.g. deleting an instance that is
+// already being deleted.
+type ErrInstanceStatus struct {
+	ChallengeID string
+	Identity    string
+	Operation   string
+	Status      string
+}
+
+var _ error = (*ErrInstanceStatus)(nil)
+
+func (err ErrInstanceStatus) Error() string {
+	return fmt.Sprintf("instance %s of challenge %s can't be %s as it is %s", err.Identity, err.ChallengeID, err.Operation, err.Status)
+}
+
+func (err ErrInstanceStatus) GRPCStatus() *status.Status {
+	return status.New(codes.FailedPrecondition, err.Error())
+}
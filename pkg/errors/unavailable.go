@@ -0,0 +1,30 @@
+package errors
+
+import (
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrChallengeUnavailable is returned when a challenge does not accept new
+// instances, either because it is not opened yet or under maintenance.
+type ErrChallengeUnavailable struct {
+	ID          string
+	Since       *time.Time
+	Maintenance bool
+}
+
+var _ error = (*ErrChallengeUnavailable)(nil)
+
+func (err ErrChallengeUnavailable) Error() string {
+	if err.Maintenance {
+		return fmt.Sprintf("challenge %s is under maintenance, no new instance can be created", err.ID)
+	}
+	return fmt.Sprintf("challenge %s opens at %s, no instance can be created before", err.ID, err.Since.Format(time.RFC3339))
+}
+
+func (err ErrChallengeUnavailable) GRPCStatus() *status.Status {
+	return status.New(codes.FailedPrecondition, err.Error())
+}
@@ -0,0 +1,62 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+
+	"github.com/ctfer-io/chall-manager/global"
+	"github.com/ctfer-io/chall-manager/pkg/fs"
+)
+
+// Events are fanned out to all replicas by overwriting a per-challenge key
+// each replica watches, thus previous events are compacted by etcd.
+func etcdKey(challengeID string) string {
+	return "/chall-manager/events/" + fs.Hash(challengeID)
+}
+
+func publishEtcd(ctx context.Context, ev Event) error {
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	_, err = global.GetEtcdManager().Put(ctx, etcdKey(ev.Instance.ChallengeID), string(b))
+	return err
+}
+
+func subscribeEtcd(ctx context.Context, challengeID string) (<-chan Event, error) {
+	wch, err := global.GetEtcdManager().Watch(ctx, etcdKey(challengeID))
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan Event, bufferSize)
+	go func() {
+		defer close(ch)
+
+		for resp := range wch {
+			if err := resp.Err(); err != nil {
+				global.Log().Error(ctx, "watching instance events", zap.Error(err))
+				return
+			}
+			for _, e := range resp.Events {
+				if e.Type != clientv3.EventTypePut {
+					continue
+				}
+				var ev Event
+				if err := json.Unmarshal(e.Kv.Value, &ev); err != nil {
+					global.Log().Error(ctx, "decoding instance event", zap.Error(err))
+					continue
+				}
+				select {
+				case ch <- ev:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return ch, nil
+}
@@ -0,0 +1,103 @@
+package events
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/ctfer-io/chall-manager/global"
+	"github.com/ctfer-io/chall-manager/pkg/fs"
+)
+
+// Type of an instance lifecycle event.
+type Type string
+
+const (
+	// Created is emitted once a new instance is registered, before it is spinned up.
+	Created Type = "created"
+	// Claimed is emitted once an instance is claimed out of the pool.
+	Claimed Type = "claimed"
+	// Ready is emitted once the stack of an instance is up.
+	Ready Type = "ready"
+	// Failed is emitted once an instance failed to be spinned up.
+	Failed Type = "failed"
+	// Renewed is emitted once an instance lifetime has been extended.
+	Renewed Type = "renewed"
+	// Updated is emitted once an instance has been updated, e.g. by an UpdateChallenge.
+	Updated Type = "updated"
+	// Reset is emitted once an instance has been destroyed then spinned up again.
+	Reset Type = "reset"
+	// Deleted is emitted once an instance has been spinned down, e.g. by the janitor.
+	Deleted Type = "deleted"
+	// Transferred is emitted to the previous owner of an instance once transferred
+	// to another source, which is emitted a Claimed event.
+	Transferred Type = "transferred"
+)
+
+// Event is a lifecycle event of a claimed challenge instance.
+type Event struct {
+	Type     Type         `json:"type"`
+	SourceID string       `json:"source_id"`
+	Members  []string     `json:"members,omitempty"`
+	Instance *fs.Instance `json:"instance"`
+	At       time.Time    `json:"at"`
+}
+
+// New creates an event for the given instance claimed by a source.
+// As events may be stored in clear text in etcd, the instance only keeps what
+// watchers are shown: its Pulumi state, flags, additional configuration and
+// secret outputs values are not part of the event.
+func New(typ Type, sourceID string, fsist *fs.Instance) Event {
+	ist := *fsist
+	ist.State = nil
+	ist.Flags = nil
+	ist.Additional = nil
+	ist.Outputs = fsist.Outputs.Public()
+	return Event{
+		Type:     typ,
+		SourceID: sourceID,
+		Members:  fsist.Members,
+		Instance: &ist,
+		At:       time.Now(),
+	}
+}
+
+// Concerns returns whether the event is about the instance of the source,
+// i.e. it claimed it or is a member of the group that did.
+func (ev Event) Concerns(sourceID string) bool {
+	return ev.SourceID == sourceID || slices.Contains(ev.Members, sourceID)
+}
+
+// Publish the event to all subscribers of the challenge, in this replica or
+// through etcd if configured.
+// Events are best-effort thus failures are logged but do not interrupt the
+// operation that emitted it.
+func Publish(ctx context.Context, ev Event) {
+	var err error
+	if global.Conf.Etcd.Endpoint == "" {
+		publishLocal(ev)
+	} else {
+		err = publishEtcd(context.WithoutCancel(ctx), ev)
+	}
+	if err != nil {
+		global.Log().Error(ctx, "publishing instance event",
+			zap.String("type", string(ev.Type)),
+			zap.Error(err),
+		)
+	}
+}
+
+// Subscribe to the events of all instances of a challenge.
+// The returned channel is closed once the context is done.
+func Subscribe(ctx context.Context, challengeID string) (<-chan Event, error) {
+	if global.Conf.Etcd.Endpoint == "" {
+		return subscribeLocal(ctx, challengeID), nil
+	}
+	return subscribeEtcd(ctx, challengeID)
+}
+
+// bufferSize is the number of events a subscriber can lag behind before
+// missing some.
+const bufferSize = 64
@@ -0,0 +1,68 @@
+package events_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ctfer-io/chall-manager/pkg/events"
+	"github.com/ctfer-io/chall-manager/pkg/fs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_U_Local(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	evs, err := events.Subscribe(ctx, "watched")
+	require.NoError(err)
+
+	// Events of other challenges are not received
+	events.Publish(ctx, events.New(events.Created, "a", &fs.Instance{ChallengeID: "other"}))
+	events.Publish(ctx, events.New(events.Renewed, "b", &fs.Instance{
+		ChallengeID: "watched",
+		State:       map[string]any{"secret": "stuff"},
+	}))
+
+	ev := <-evs
+	assert.Equal(events.Renewed, ev.Type)
+	assert.Equal("b", ev.SourceID)
+	assert.Nil(ev.Instance.State)
+
+	// Once canceled, the subscription ends
+	cancel()
+	_, ok := <-evs
+	assert.False(ok)
+}
+
+func Test_U_New(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+
+	ev := events.New(events.Claimed, "owner", &fs.Instance{
+		ChallengeID: "chall",
+		Flags:       []string{"FLAG{secret}"},
+		Additional:  map[string]string{"password": "secret"},
+		Outputs: fs.Outputs{
+			"endpoint": {Value: "10.0.0.1"},
+			"password": {Value: "secret", Secret: true},
+		},
+		Members: []string{"member"},
+	})
+
+	// Secrets are not part of the event
+	assert.Nil(ev.Instance.Flags)
+	assert.Nil(ev.Instance.Additional)
+	assert.Equal("10.0.0.1", ev.Instance.Outputs["endpoint"].Value)
+	assert.Nil(ev.Instance.Outputs["password"].Value)
+	assert.True(ev.Instance.Outputs["password"].Secret)
+
+	// It concerns the owner and the members of its group
+	assert.True(ev.Concerns("owner"))
+	assert.True(ev.Concerns("member"))
+	assert.False(ev.Concerns("other"))
+}
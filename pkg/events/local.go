@@ -0,0 +1,49 @@
+package events
+
+import (
+	"context"
+	"sync"
+)
+
+var (
+	localSubs   = map[string]map[chan Event]struct{}{}
+	localSubsMx sync.RWMutex
+)
+
+func publishLocal(ev Event) {
+	localSubsMx.RLock()
+	defer localSubsMx.RUnlock()
+
+	for ch := range localSubs[ev.Instance.ChallengeID] {
+		select {
+		case ch <- ev:
+		default: // slow subscribers miss events rather than slowing down operations
+		}
+	}
+}
+
+func subscribeLocal(ctx context.Context, challengeID string) <-chan Event {
+	ch := make(chan Event, bufferSize)
+
+	localSubsMx.Lock()
+	subs, ok := localSubs[challengeID]
+	if !ok {
+		subs = map[chan Event]struct{}{}
+		localSubs[challengeID] = subs
+	}
+	subs[ch] = struct{}{}
+	localSubsMx.Unlock()
+
+	go func() {
+		<-ctx.Done()
+
+		localSubsMx.Lock()
+		delete(subs, ch)
+		if len(subs) == 0 {
+			delete(localSubs, challengeID)
+		}
+		close(ch)
+		localSubsMx.Unlock()
+	}()
+	return ch
+}
@@ -22,6 +22,51 @@ type Challenge struct {
 	Additional map[string]string `json:"additional,omitempty"`
 	Min        int64             `json:"min"`
 	Max        int64             `json:"max"`
+
+	// Labels to select challenges by, e.g. their category or difficulty.
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// MaxInstancesPerSource overrides the server-wide configuration, if set.
+	MaxInstancesPerSource *int64 `json:"max_instances_per_source,omitempty"`
+
+	// Renewal policy: the maximum number of renewals (0 means no limit), and the
+	// maximum lifetime of an instance since its creation.
+	MaxRenews   int64          `json:"max_renews,omitempty"`
+	MaxLifetime *time.Duration `json:"max_lifetime,omitempty"`
+
+	// Shared instances are owned by groups, which members resolve to.
+	Shared bool `json:"shared,omitempty"`
+
+	// Since is the date before which no instance can be created, though the
+	// pool is provisioned ahead.
+	Since *time.Time `json:"since,omitempty"`
+
+	// Maintenance refuses new instances, while existing ones keep running.
+	Maintenance bool `json:"maintenance,omitempty"`
+
+	// Revision is the latest revision applied, see Revision.
+	Revision int64 `json:"revision,omitempty"`
+}
+
+// InstancesQuota returns the maximum number of instances a source can have
+// at once, when creating an instance of this challenge. 0 means no limit.
+func (chall *Challenge) InstancesQuota() int64 {
+	if chall.MaxInstancesPerSource != nil {
+		return *chall.MaxInstancesPerSource
+	}
+	return global.Conf.MaxInstancesPerSource
+}
+
+// Unavailable returns the reason why no new instance can be created at the
+// given date, if any.
+func (chall *Challenge) Unavailable(now time.Time) error {
+	if chall.Maintenance {
+		return &errs.ErrChallengeUnavailable{ID: chall.ID, Maintenance: true}
+	}
+	if chall.Since != nil && now.Before(*chall.Since) {
+		return &errs.ErrChallengeUnavailable{ID: chall.ID, Since: chall.Since}
+	}
+	return nil
 }
 
 func ChallengeDirectory(id string) string {
@@ -0,0 +1,53 @@
+package fs_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	errs "github.com/ctfer-io/chall-manager/pkg/errors"
+	"github.com/ctfer-io/chall-manager/pkg/fs"
+)
+
+func Test_U_Unavailable(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	past, future := now.Add(-time.Hour), now.Add(time.Hour)
+
+	var tests = map[string]struct {
+		Challenge *fs.Challenge
+		ExpectErr bool
+	}{
+		"opened": {
+			Challenge: &fs.Challenge{},
+			ExpectErr: false,
+		},
+		"opened-since": {
+			Challenge: &fs.Challenge{Since: &past},
+			ExpectErr: false,
+		},
+		"not-opened": {
+			Challenge: &fs.Challenge{Since: &future},
+			ExpectErr: true,
+		},
+		"maintenance": {
+			Challenge: &fs.Challenge{Since: &past, Maintenance: true},
+			ExpectErr: true,
+		},
+	}
+
+	for testname, tt := range tests {
+		t.Run(testname, func(t *testing.T) {
+			assert := assert.New(t)
+
+			err := tt.Challenge.Unavailable(now)
+			if tt.ExpectErr {
+				assert.IsType(&errs.ErrChallengeUnavailable{}, err)
+				return
+			}
+			assert.NoError(err)
+		})
+	}
+}
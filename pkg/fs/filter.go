@@ -0,0 +1,163 @@
+package fs
+
+import (
+	"os"
+	"slices"
+	"time"
+
+	errs "github.com/ctfer-io/chall-manager/pkg/errors"
+	"github.com/ctfer-io/chall-manager/pkg/labels"
+)
+
+// InstanceFilter selects challenge instances.
+// The zero value matches all instances.
+type InstanceFilter struct {
+	// ChallengeIDs, if not empty, restricts to the instances of those challenges.
+	ChallengeIDs []string
+	// SourceIDs, if not empty, restricts to the instances claimed by those sources,
+	// or by the groups they are members of.
+	SourceIDs []string
+	// Claimed, if not nil, restricts to the claimed (true) or pooled (false) instances.
+	Claimed *bool
+	// ExpiringBefore, if not nil, restricts to the instances that expire before this date.
+	ExpiringBefore *time.Time
+	// Labels, if not empty, restricts to the instances of the challenges which
+	// labels match this selector.
+	Labels labels.Selector
+	// After, if not empty, restricts to the instances which identity sorts after
+	// this one, e.g. to resume a pagination.
+	After string
+}
+
+// ClaimedInstance is an instance along the source that claimed it, if any.
+type ClaimedInstance struct {
+	*Instance
+	SourceID string
+}
+
+// FilterChallenges returns the sorted identifiers of the challenges that match
+// the filter.
+func FilterChallenges(f *InstanceFilter) ([]string, error) {
+	var ids []string
+	if len(f.ChallengeIDs) == 0 {
+		all, err := ListChallenges()
+		if err != nil {
+			return nil, err
+		}
+		ids = all
+	} else {
+		ids = make([]string, 0, len(f.ChallengeIDs))
+		for _, id := range f.ChallengeIDs {
+			if err := CheckChallenge(id); err != nil {
+				continue
+			}
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+	ids = slices.Compact(ids)
+
+	if f.Labels.Empty() {
+		return ids, nil
+	}
+	out := make([]string, 0, len(ids))
+	for _, id := range ids {
+		fschall, err := LoadChallenge(id)
+		if err != nil {
+			return nil, err
+		}
+		if f.Labels.Matches(fschall.Labels) {
+			out = append(out, id)
+		}
+	}
+	return out, nil
+}
+
+// FilterInstances returns the instances of a challenge that match the filter,
+// sorted by identity.
+func FilterInstances(challID string, f *InstanceFilter) ([]*ClaimedInstance, error) {
+	out := []*ClaimedInstance{}
+	if err := WalkInstances(challID, f, func(ist *ClaimedInstance) bool {
+		out = append(out, ist)
+		return true
+	}); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// WalkInstances calls yield on the instances of a challenge that match the
+// filter, sorted by identity, until it returns false.
+// Claims are checked first, such that only the instances that could match have
+// their information loaded.
+func WalkInstances(challID string, f *InstanceFilter, yield func(*ClaimedInstance) bool) error {
+	ists, err := ListInstances(challID)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// No instance yet
+			return nil
+		}
+		return &errs.ErrInternal{Sub: err}
+	}
+	slices.Sort(ists)
+
+	for _, ist := range ists {
+		if ist <= f.After {
+			continue
+		}
+		src, _ := LookupClaim(challID, ist) // error means it is in pool
+		if !f.matchClaim(src) {
+			continue
+		}
+		ok, err := f.matchSources(challID, ist, src)
+		if err != nil {
+			return &errs.ErrInternal{Sub: err}
+		}
+		if !ok {
+			continue
+		}
+
+		fsist, err := LoadInstance(challID, ist)
+		if err != nil {
+			return err
+		}
+		if !f.matchUntil(fsist.Until) {
+			continue
+		}
+		if !yield(&ClaimedInstance{
+			Instance: fsist,
+			SourceID: src,
+		}) {
+			return nil
+		}
+	}
+	return nil
+}
+
+func (f *InstanceFilter) matchClaim(sourceID string) bool {
+	return f.Claimed == nil || *f.Claimed == (sourceID != "")
+}
+
+func (f *InstanceFilter) matchSources(challID, identity, sourceID string) (bool, error) {
+	if len(f.SourceIDs) == 0 || slices.Contains(f.SourceIDs, sourceID) {
+		return true, nil
+	}
+	if sourceID == "" {
+		// Pooled instances have no member
+		return false, nil
+	}
+	members, err := LookupMembers(challID, identity)
+	if err != nil {
+		return false, err
+	}
+	return slices.ContainsFunc(members, func(m string) bool {
+		return slices.Contains(f.SourceIDs, m)
+	}), nil
+}
+
+func (f *InstanceFilter) matchUntil(until *time.Time) bool {
+	if f.ExpiringBefore == nil {
+		return true
+	}
+	return until != nil && until.Before(*f.ExpiringBefore)
+}
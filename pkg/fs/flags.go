@@ -0,0 +1,108 @@
+package fs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	json "github.com/goccy/go-json"
+
+	"github.com/ctfer-io/chall-manager/global"
+	errs "github.com/ctfer-io/chall-manager/pkg/errors"
+)
+
+const flagsSubdir = "flags"
+
+// FlagRecord keeps track of the flags that were issued to a source, once the
+// instance no longer holds them (e.g. deleted, transferred or recreated).
+// They are stored in a bounded history (at `<global.Conf.Directory>/chall/<id>/flags/`).
+type FlagRecord struct {
+	SourceID string    `json:"source_id"`
+	Members  []string  `json:"members,omitempty"`
+	Identity string    `json:"identity"`
+	Flags    []string  `json:"flags"`
+	At       time.Time `json:"at"`
+}
+
+// IssuedTo returns whether the flags were issued to the source, directly or
+// through the group it was a member of.
+func (rec *FlagRecord) IssuedTo(sourceID string) bool {
+	return rec.SourceID == sourceID || slices.Contains(rec.Members, sourceID)
+}
+
+// ArchiveFlags records the flags of the instance as issued to the source, then
+// trims the history to the configured size.
+// Nothing is recorded if the instance has no flag or the history is disabled.
+func (ist *Instance) ArchiveFlags(sourceID string) error {
+	size := global.Conf.FlagHistory
+	if len(ist.Flags) == 0 || size == 0 {
+		return nil
+	}
+
+	dir := filepath.Join(ChallengeDirectory(ist.ChallengeID), flagsSubdir)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return &errs.ErrInternal{Sub: err}
+	}
+
+	rec := &FlagRecord{
+		SourceID: sourceID,
+		Members:  ist.Members,
+		Identity: ist.Identity,
+		Flags:    ist.Flags,
+		At:       time.Now(),
+	}
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return &errs.ErrInternal{Sub: err}
+	}
+	// File names are ordered by time, such that the oldest are trimmed first
+	fname := fmt.Sprintf("%020d-%s.json", rec.At.UnixNano(), ist.Identity)
+	if err := os.WriteFile(filepath.Join(dir, fname), b, 0o600); err != nil {
+		return &errs.ErrInternal{Sub: err}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return &errs.ErrInternal{Sub: err}
+	}
+	for i := 0; i < len(entries)-int(size); i++ {
+		// Concurrent archivals may already have trimmed it
+		if err := os.Remove(filepath.Join(dir, entries[i].Name())); err != nil && !os.IsNotExist(err) {
+			return &errs.ErrInternal{Sub: err}
+		}
+	}
+	return nil
+}
+
+// LoadFlagHistory returns the flag records of a challenge, from the most
+// recent to the oldest.
+func LoadFlagHistory(challID string) ([]*FlagRecord, error) {
+	dir := filepath.Join(ChallengeDirectory(challID), flagsSubdir)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, &errs.ErrInternal{Sub: err}
+	}
+
+	recs := make([]*FlagRecord, 0, len(entries))
+	for _, entry := range slices.Backward(entries) {
+		b, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			if os.IsNotExist(err) {
+				// Trimmed concurrently
+				continue
+			}
+			return nil, &errs.ErrInternal{Sub: err}
+		}
+		rec := &FlagRecord{}
+		if err := json.Unmarshal(b, rec); err != nil {
+			return nil, &errs.ErrInternal{Sub: err}
+		}
+		recs = append(recs, rec)
+	}
+	return recs, nil
+}
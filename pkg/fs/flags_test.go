@@ -0,0 +1,41 @@
+package fs_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ctfer-io/chall-manager/global"
+	"github.com/ctfer-io/chall-manager/pkg/fs"
+)
+
+func Test_U_FlagHistory(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	global.Conf.Directory = t.TempDir()
+	global.Conf.FlagHistory = 2
+	require.NoError(os.Mkdir(filepath.Join(global.Conf.Directory, "chall"), os.ModePerm))
+
+	fschall := &fs.Challenge{ID: "chall"}
+	require.NoError(fschall.Save())
+
+	for _, src := range []string{"a", "b", "c"} {
+		fsist := &fs.Instance{
+			ChallengeID: "chall",
+			Identity:    src,
+			Flags:       []string{"FLAG{" + src + "}"},
+		}
+		require.NoError(fsist.ArchiveFlags(src))
+	}
+
+	// Only the most recent records are kept, from the most recent one
+	recs, err := fs.LoadFlagHistory("chall")
+	require.NoError(err)
+	require.Len(recs, 2)
+	assert.Equal("c", recs[0].SourceID)
+	assert.Equal("b", recs[1].SourceID)
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"time"
 
 	json "github.com/goccy/go-json"
@@ -23,6 +25,62 @@ type Instance struct {
 	ConnectionInfo string            `json:"connection_info"`
 	Flags          []string          `json:"flags,omitempty"`
 	Additional     map[string]string `json:"additional,omitempty"`
+	Status         InstanceStatus    `json:"status,omitempty"`
+	Reason         string            `json:"reason,omitempty"`
+	Renews         int64             `json:"renews,omitempty"`
+	Outputs        Outputs           `json:"outputs,omitempty"`
+
+	// Scenario the instance runs, and the challenge revision it comes from.
+	// They lag behind the challenge ones after a deferred update, until the
+	// instance is migrated.
+	Scenario string `json:"scenario,omitempty"`
+	Revision int64  `json:"revision,omitempty"`
+
+	// Members of the group that claimed the instance, if the challenge instances
+	// are shared. They are stored aside of the claim, not in the info file.
+	Members []string `json:"-"`
+}
+
+// InstanceStatus is the lifecycle status of an instance.
+// An empty status is considered ready, for retrocompatibility with instances
+// saved before its introduction.
+type InstanceStatus string
+
+const (
+	StatusReady        InstanceStatus = "ready"
+	StatusProvisioning InstanceStatus = "provisioning"
+	StatusFailed       InstanceStatus = "failed"
+	StatusDeleting     InstanceStatus = "deleting"
+)
+
+// Outdated returns whether the instance lags behind the given challenge revision,
+// e.g. after a deferred update. Instances saved before revisions were tracked
+// have none, thus lag behind as soon as the challenge is updated.
+func (ist *Instance) Outdated(revision int64) bool {
+	return ist.Revision < revision
+}
+
+// Start the lifetime of the instance, e.g. once claimed from the pool: its
+// lifetime and renewals are counted from now on.
+func (ist *Instance) Start(now time.Time, until *time.Time) {
+	ist.Since = now
+	ist.LastRenew = now
+	ist.Until = until
+	ist.Renews = 0
+}
+
+// RunningScenario returns the scenario the instance runs, or the fallback for
+// instances saved before scenarios were tracked.
+func (ist *Instance) RunningScenario(fallback string) string {
+	if ist.Scenario != "" {
+		return ist.Scenario
+	}
+	return fallback
+}
+
+// IsReady returns whether the instance is up and running.
+func (ist *Instance) IsReady() bool {
+	return ist.Status == "" || ist.Status == StatusReady
 }
 
 func Claim(challID, identity, sourceID string) error {
@@ -55,6 +113,22 @@ func (ist *Instance) Claim(sourceID string) error {
 	return os.WriteFile(claimPath, []byte(sourceID), 0o600)
 }
 
+// Transfer atomically rewrites the claim of the instance for the given source.
+// The members of the previous owner group are removed, as they are not part of
+// the new owner one.
+func (ist *Instance) Transfer(sourceID string) error {
+	idir := InstanceDirectory(ist.ChallengeID, ist.Identity)
+	tmpPath := filepath.Join(idir, "claim.tmp")
+	if err := os.WriteFile(tmpPath, []byte(sourceID), 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, filepath.Join(idir, "claim")); err != nil {
+		return err
+	}
+	ist.Members = nil
+	return ist.SaveMembers()
+}
+
 func (ist *Instance) IsClaimed() bool {
 	claimPath := filepath.Join(InstanceDirectory(ist.ChallengeID, ist.Identity), "claim")
 	_, err := os.Stat(claimPath)
@@ -70,10 +144,69 @@ func LookupClaim(challID, identity string) (string, error) {
 	return string(b), nil
 }
 
+// LookupMembers returns the members of the group that claimed the instance.
+func LookupMembers(challID, identity string) ([]string, error) {
+	membersPath := filepath.Join(InstanceDirectory(challID, identity), "members")
+	b, err := os.ReadFile(membersPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return strings.Fields(string(b)), nil
+}
+
+// AddMember adds a member to the group that claimed the instance.
+func (ist *Instance) AddMember(sourceID string) error {
+	members, err := LookupMembers(ist.ChallengeID, ist.Identity)
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(members, sourceID) {
+		members = append(members, sourceID)
+	}
+	ist.Members = members
+	return ist.SaveMembers()
+}
+
+// RemoveMember removes a member from the group that claimed the instance.
+func (ist *Instance) RemoveMember(sourceID string) error {
+	members, err := LookupMembers(ist.ChallengeID, ist.Identity)
+	if err != nil {
+		return err
+	}
+	ist.Members = slices.DeleteFunc(members, func(m string) bool {
+		return m == sourceID
+	})
+	return ist.SaveMembers()
+}
+
+// SaveMembers writes the members of the group that claimed the instance.
+func (ist *Instance) SaveMembers() error {
+	membersPath := filepath.Join(InstanceDirectory(ist.ChallengeID, ist.Identity), "members")
+	if len(ist.Members) == 0 {
+		if err := os.Remove(membersPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return os.WriteFile(membersPath, []byte(strings.Join(ist.Members, "\n")), 0o600)
+}
+
+// FindInstance returns the identity of the instance the source claimed, or
+// that its group claimed if it is a member of it.
 func FindInstance(challID, sourceID string) (string, error) {
+	id, _, err := ResolveInstance(challID, sourceID)
+	return id, err
+}
+
+// ResolveInstance returns the identity of the instance the source claimed, or
+// that its group claimed if it is a member of it, along the source that owns it.
+func ResolveInstance(challID, sourceID string) (identity, owner string, err error) {
 	ists, err := ListInstances(challID)
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
 	for _, ist := range ists {
 		src, err := LookupClaim(challID, ist)
@@ -82,16 +215,39 @@ func FindInstance(challID, sourceID string) (string, error) {
 			continue
 		}
 		if src == sourceID {
-			return ist, nil
+			return ist, src, nil
+		}
+		members, err := LookupMembers(challID, ist)
+		if err != nil {
+			return "", "", err
+		}
+		if slices.Contains(members, sourceID) {
+			return ist, src, nil
 		}
 	}
-	return "", &errs.ErrInstanceExist{
+	return "", "", &errs.ErrInstanceExist{
 		ChallengeID: challID,
 		SourceID:    sourceID,
 		Exist:       false,
 	}
 }
 
+// CountInstances returns the number of instances claimed by the source over
+// all challenges, including the ones of the groups it is a member of.
+func CountInstances(sourceID string) (int64, error) {
+	challs, err := ListChallenges()
+	if err != nil {
+		return 0, err
+	}
+	var count int64
+	for _, challID := range challs {
+		if _, err := FindInstance(challID, sourceID); err == nil {
+			count++
+		}
+	}
+	return count, nil
+}
+
 func InstanceDirectory(challID, identity string) string {
 	return filepath.Join(ChallengeDirectory(challID), instanceSubdir, identity)
 }
@@ -139,6 +295,10 @@ func LoadInstance(challID, identity string) (*Instance, error) {
 	if err := dec.Decode(fsist); err != nil {
 		return nil, &errs.ErrInternal{Sub: err}
 	}
+	fsist.Members, err = LookupMembers(challID, identity)
+	if err != nil {
+		return nil, &errs.ErrInternal{Sub: err}
+	}
 	return fsist, nil
 }
 
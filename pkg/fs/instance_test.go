@@ -0,0 +1,122 @@
+package fs_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ctfer-io/chall-manager/global"
+	errs "github.com/ctfer-io/chall-manager/pkg/errors"
+	"github.com/ctfer-io/chall-manager/pkg/fs"
+)
+
+func Test_U_ResolveInstance(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	global.Conf.Directory = t.TempDir()
+	require.NoError(os.Mkdir(filepath.Join(global.Conf.Directory, "chall"), os.ModePerm))
+
+	fschall := &fs.Challenge{ID: "chall", Shared: true}
+	require.NoError(fschall.Save())
+	fsist := &fs.Instance{ChallengeID: "chall", Identity: "identity"}
+	require.NoError(fsist.Save())
+	require.NoError(fsist.Claim("team"))
+	require.NoError(fsist.AddMember("player-1"))
+	require.NoError(fsist.AddMember("player-2"))
+
+	// Both the group and its members resolve to the instance
+	for _, src := range []string{"team", "player-1", "player-2"} {
+		id, owner, err := fs.ResolveInstance("chall", src)
+		require.NoError(err)
+		assert.Equal("identity", id)
+		assert.Equal("team", owner)
+	}
+
+	// Removed members no longer resolve to it
+	require.NoError(fsist.RemoveMember("player-1"))
+	_, _, err := fs.ResolveInstance("chall", "player-1")
+	assert.IsType(&errs.ErrInstanceExist{}, err)
+
+	loaded, err := fs.LoadInstance("chall", "identity")
+	require.NoError(err)
+	assert.Equal([]string{"player-2"}, loaded.Members)
+}
+
+func Test_U_Transfer(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	global.Conf.Directory = t.TempDir()
+	require.NoError(os.Mkdir(filepath.Join(global.Conf.Directory, "chall"), os.ModePerm))
+
+	fschall := &fs.Challenge{ID: "chall"}
+	require.NoError(fschall.Save())
+	fsist := &fs.Instance{ChallengeID: "chall", Identity: "identity"}
+	require.NoError(fsist.Save())
+	require.NoError(fsist.Claim("from"))
+
+	require.NoError(fsist.Transfer("to"))
+
+	src, err := fs.LookupClaim("chall", "identity")
+	require.NoError(err)
+	assert.Equal("to", src)
+	_, err = fs.FindInstance("chall", "from")
+	assert.IsType(&errs.ErrInstanceExist{}, err)
+}
+
+func Test_U_Outdated(t *testing.T) {
+	t.Parallel()
+
+	var tests = map[string]struct {
+		Revision  int64
+		Challenge int64
+		Expected  bool
+	}{
+		"legacy": {
+			Revision:  0,
+			Challenge: 0,
+			Expected:  false,
+		},
+		"legacy-lagging": {
+			Revision:  0,
+			Challenge: 2,
+			Expected:  true,
+		},
+		"lagging": {
+			Revision:  1,
+			Challenge: 2,
+			Expected:  true,
+		},
+		"current": {
+			Revision:  2,
+			Challenge: 2,
+			Expected:  false,
+		},
+	}
+
+	for testname, tt := range tests {
+		t.Run(testname, func(t *testing.T) {
+			assert := assert.New(t)
+
+			fsist := &fs.Instance{Revision: tt.Revision}
+			assert.Equal(tt.Expected, fsist.Outdated(tt.Challenge))
+		})
+	}
+}
+
+func Test_U_RunningScenario(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	// Legacy instances run the challenge scenario
+	fsist := &fs.Instance{}
+	assert.Equal("registry.lan/chall:v2", fsist.RunningScenario("registry.lan/chall:v2"))
+
+	// Others keep running theirs, e.g. when left behind by a deferred update
+	fsist.Scenario = "registry.lan/chall:v1"
+	assert.Equal("registry.lan/chall:v1", fsist.RunningScenario("registry.lan/chall:v2"))
+}
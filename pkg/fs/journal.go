@@ -0,0 +1,115 @@
+package fs
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"time"
+
+	json "github.com/goccy/go-json"
+
+	errs "github.com/ctfer-io/chall-manager/pkg/errors"
+	"github.com/ctfer-io/chall-manager/pkg/otel"
+)
+
+const journalFile = "journal.jsonl"
+
+// Operation is an instance operation recorded in the challenge journal.
+type Operation string
+
+const (
+	OpCreate    Operation = "create"
+	OpProvision Operation = "provision"
+	OpRenew     Operation = "renew"
+	OpReset     Operation = "reset"
+	OpTransfer  Operation = "transfer"
+	OpRefresh   Operation = "refresh"
+	OpRepair    Operation = "repair"
+	OpCleanup   Operation = "cleanup"
+	OpCancel    Operation = "cancel"
+	OpDelete    Operation = "delete"
+)
+
+// Outcome is the result of an operation recorded in the challenge journal.
+type Outcome string
+
+const (
+	OutcomeSuccess Outcome = "success"
+	OutcomeFailure Outcome = "failure"
+)
+
+// JournalEntry is an instance operation, as recorded in the append-only
+// journal of its challenge (at `<global.Conf.Directory>/chall/<id>/journal.jsonl`).
+// Entries outlive the instances, such that what happened can be audited
+// after the fact.
+type JournalEntry struct {
+	At        time.Time     `json:"at"`
+	Operation Operation     `json:"operation"`
+	SourceID  string        `json:"source_id,omitempty"`
+	Identity  string        `json:"identity,omitempty"`
+	Outcome   Outcome       `json:"outcome"`
+	Error     string        `json:"error,omitempty"`
+	Duration  time.Duration `json:"duration"`
+	Caller    otel.Caller   `json:"caller"`
+}
+
+// AppendJournal appends the entry to the journal of the challenge.
+func AppendJournal(challID string, entry *JournalEntry) error {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return &errs.ErrInternal{Sub: err}
+	}
+	fpath := filepath.Join(ChallengeDirectory(challID), journalFile)
+	f, err := os.OpenFile(fpath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &errs.ErrChallengeExist{
+				ID:    challID,
+				Exist: false,
+			}
+		}
+		return &errs.ErrInternal{Sub: err}
+	}
+	defer fclose(f)
+
+	// Write the line at once for concurrent appends not to interleave
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		return &errs.ErrInternal{Sub: err}
+	}
+	return nil
+}
+
+// ReadJournal returns the entries of the challenge journal recorded in the
+// [from, to) time range, in order. A nil bound is open.
+func ReadJournal(challID string, from, to *time.Time) ([]*JournalEntry, error) {
+	fpath := filepath.Join(ChallengeDirectory(challID), journalFile)
+	f, err := os.Open(fpath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, &errs.ErrInternal{Sub: err}
+	}
+	defer fclose(f)
+
+	entries := []*JournalEntry{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		entry := &JournalEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), entry); err != nil {
+			// Line is being written concurrently
+			continue
+		}
+		if from != nil && entry.At.Before(*from) {
+			continue
+		}
+		if to != nil && !entry.At.Before(*to) {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, &errs.ErrInternal{Sub: err}
+	}
+	return entries, nil
+}
@@ -0,0 +1,50 @@
+package fs_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ctfer-io/chall-manager/global"
+	"github.com/ctfer-io/chall-manager/pkg/fs"
+)
+
+func Test_U_Journal(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	global.Conf.Directory = t.TempDir()
+	require.NoError(os.Mkdir(filepath.Join(global.Conf.Directory, "chall"), os.ModePerm))
+
+	fschall := &fs.Challenge{ID: "chall"}
+	require.NoError(fschall.Save())
+
+	t0 := time.Now()
+	for i, op := range []fs.Operation{fs.OpCreate, fs.OpRenew, fs.OpDelete} {
+		require.NoError(fs.AppendJournal("chall", &fs.JournalEntry{
+			At:        t0.Add(time.Duration(i) * time.Minute),
+			Operation: op,
+			SourceID:  "source",
+			Outcome:   fs.OutcomeSuccess,
+		}))
+	}
+
+	// Entries remain once the instance is gone, and are filtered by time range
+	entries, err := fs.ReadJournal("chall", nil, nil)
+	require.NoError(err)
+	assert.Len(entries, 3)
+
+	from, to := t0.Add(time.Minute), t0.Add(2*time.Minute)
+	entries, err = fs.ReadJournal("chall", &from, &to)
+	require.NoError(err)
+	require.Len(entries, 1)
+	assert.Equal(fs.OpRenew, entries[0].Operation)
+
+	// Journal of an unknown challenge can't be appended to
+	err = fs.AppendJournal("unknown", &fs.JournalEntry{})
+	assert.Error(err)
+}
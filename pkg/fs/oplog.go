@@ -0,0 +1,149 @@
+package fs
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	json "github.com/goccy/go-json"
+
+	errs "github.com/ctfer-io/chall-manager/pkg/errors"
+)
+
+const logsSubdir = "logs"
+
+// OperationLog is the engine output of the last stack operation of a kind
+// (e.g. up, down) on an instance, as it is stored on the filesystem (at
+// `<global.Conf.Directory>/chall/<id>/instance/<id>/logs/<operation>.json`).
+type OperationLog struct {
+	Operation string    `json:"operation"`
+	At        time.Time `json:"at"`
+	Error     string    `json:"error,omitempty"`
+	Output    string    `json:"output"`
+	Truncated bool      `json:"truncated,omitempty"`
+}
+
+// OperationLogSink stores the logs of stack operations.
+type OperationLogSink interface {
+	SaveOperationLog(log *OperationLog) error
+}
+
+var (
+	_ OperationLogSink = (*Instance)(nil)
+	_ OperationLogSink = (*RetainedLogs)(nil)
+)
+
+// RetainedLogs are the operation logs of an instance kept at the challenge level
+// (at `<global.Conf.Directory>/chall/<id>/logs/<identity>/<operation>.json`),
+// such that they outlive it, e.g. when it failed to spin up.
+// They are referenced by the identity recorded in the challenge journal.
+type RetainedLogs struct {
+	ChallengeID string
+	Identity    string
+}
+
+// SaveOperationLog stores the log, replacing the previous one of the same operation.
+func (ist *Instance) SaveOperationLog(log *OperationLog) error {
+	return saveOperationLog(filepath.Join(InstanceDirectory(ist.ChallengeID, ist.Identity), logsSubdir), log)
+}
+
+// SaveOperationLog stores the log, replacing the previous one of the same operation.
+func (rl *RetainedLogs) SaveOperationLog(log *OperationLog) error {
+	return saveOperationLog(retainedLogsDirectory(rl.ChallengeID, rl.Identity), log)
+}
+
+// RetainOperationLogs moves the instance operation logs at the challenge level,
+// such that they outlive the instance once deleted.
+func (ist *Instance) RetainOperationLogs() error {
+	return moveOperationLogs(
+		filepath.Join(InstanceDirectory(ist.ChallengeID, ist.Identity), logsSubdir),
+		retainedLogsDirectory(ist.ChallengeID, ist.Identity),
+	)
+}
+
+// AdoptOperationLogs moves the operation logs retained for the instance alongside
+// it, e.g. once registered.
+func (ist *Instance) AdoptOperationLogs() error {
+	return moveOperationLogs(
+		retainedLogsDirectory(ist.ChallengeID, ist.Identity),
+		filepath.Join(InstanceDirectory(ist.ChallengeID, ist.Identity), logsSubdir),
+	)
+}
+
+// LoadOperationLogs returns the logs of the instance operations, from the
+// oldest to the most recent.
+func LoadOperationLogs(challID, identity string) ([]*OperationLog, error) {
+	return loadOperationLogs(filepath.Join(InstanceDirectory(challID, identity), logsSubdir))
+}
+
+// LoadRetainedOperationLogs returns the logs of the operations retained for the
+// instance, from the oldest to the most recent.
+func LoadRetainedOperationLogs(challID, identity string) ([]*OperationLog, error) {
+	return loadOperationLogs(retainedLogsDirectory(challID, identity))
+}
+
+func retainedLogsDirectory(challID, identity string) string {
+	return filepath.Join(ChallengeDirectory(challID), logsSubdir, identity)
+}
+
+func moveOperationLogs(src, dst string) error {
+	if _, err := os.Stat(src); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return &errs.ErrInternal{Sub: err}
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		return &errs.ErrInternal{Sub: err}
+	}
+	if err := os.RemoveAll(dst); err != nil {
+		return &errs.ErrInternal{Sub: err}
+	}
+	if err := os.Rename(src, dst); err != nil {
+		return &errs.ErrInternal{Sub: err}
+	}
+	return nil
+}
+
+func saveOperationLog(dir string, log *OperationLog) error {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return &errs.ErrInternal{Sub: err}
+	}
+
+	b, err := json.Marshal(log)
+	if err != nil {
+		return &errs.ErrInternal{Sub: err}
+	}
+	if err := os.WriteFile(filepath.Join(dir, log.Operation+".json"), b, 0o600); err != nil {
+		return &errs.ErrInternal{Sub: err}
+	}
+	return nil
+}
+
+func loadOperationLogs(dir string) ([]*OperationLog, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, &errs.ErrInternal{Sub: err}
+	}
+
+	logs := make([]*OperationLog, 0, len(entries))
+	for _, entry := range entries {
+		b, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, &errs.ErrInternal{Sub: err}
+		}
+		log := &OperationLog{}
+		if err := json.Unmarshal(b, log); err != nil {
+			return nil, &errs.ErrInternal{Sub: err}
+		}
+		logs = append(logs, log)
+	}
+	slices.SortFunc(logs, func(a, b *OperationLog) int {
+		return a.At.Compare(b.At)
+	})
+	return logs, nil
+}
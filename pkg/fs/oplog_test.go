@@ -0,0 +1,59 @@
+package fs_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ctfer-io/chall-manager/global"
+	"github.com/ctfer-io/chall-manager/pkg/fs"
+)
+
+func Test_U_RetainOperationLogs(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	global.Conf.Directory = t.TempDir()
+	require.NoError(os.Mkdir(filepath.Join(global.Conf.Directory, "chall"), os.ModePerm))
+
+	fschall := &fs.Challenge{ID: "chall"}
+	require.NoError(fschall.Save())
+	fsist := &fs.Instance{ChallengeID: "chall", Identity: "identity"}
+	require.NoError(fsist.Save())
+
+	require.NoError(fsist.SaveOperationLog(&fs.OperationLog{
+		Operation: "up",
+		At:        time.Now(),
+		Error:     "failed",
+		Output:    "output",
+	}))
+
+	// Logs outlive the instance once retained
+	require.NoError(fsist.RetainOperationLogs())
+	require.NoError(fsist.Delete())
+
+	logs, err := fs.LoadOperationLogs("chall", "identity")
+	require.NoError(err)
+	assert.Empty(logs)
+
+	logs, err = fs.LoadRetainedOperationLogs("chall", "identity")
+	require.NoError(err)
+	require.Len(logs, 1)
+	assert.Equal("failed", logs[0].Error)
+
+	// Logs are moved back alongside the instance once adopted
+	require.NoError(fsist.Save())
+	require.NoError(fsist.AdoptOperationLogs())
+
+	logs, err = fs.LoadOperationLogs("chall", "identity")
+	require.NoError(err)
+	assert.Len(logs, 1)
+
+	logs, err = fs.LoadRetainedOperationLogs("chall", "identity")
+	require.NoError(err)
+	assert.Empty(logs)
+}
@@ -0,0 +1,57 @@
+package fs
+
+import (
+	"slices"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// Output is a typed value produced by the scenario, beyond the connection
+// information (e.g. credentials, endpoints or SSH keys).
+type Output struct {
+	Value  any  `json:"value"`
+	Secret bool `json:"secret,omitempty"`
+}
+
+// Outputs of an instance, by name.
+// Secret values are masked when logged.
+type Outputs map[string]Output
+
+var _ zapcore.ObjectMarshaler = (Outputs)(nil)
+
+const masked = "[secret]"
+
+func (outs Outputs) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	keys := make([]string, 0, len(outs))
+	for k := range outs {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	for _, k := range keys {
+		out := outs[k]
+		if out.Secret {
+			enc.AddString(k, masked)
+			continue
+		}
+		if err := enc.AddReflected(k, out.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Public returns the outputs without the values of the secret ones.
+func (outs Outputs) Public() Outputs {
+	if outs == nil {
+		return nil
+	}
+	pub := make(Outputs, len(outs))
+	for k, out := range outs {
+		if out.Secret {
+			out.Value = nil
+		}
+		pub[k] = out
+	}
+	return pub
+}
@@ -0,0 +1,122 @@
+package fs
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"time"
+
+	json "github.com/goccy/go-json"
+
+	errs "github.com/ctfer-io/chall-manager/pkg/errors"
+)
+
+const revisionsFile = "revisions.jsonl"
+
+// Revision is a challenge configuration once applied, as recorded in the
+// append-only history of the challenge (at `<global.Conf.Directory>/chall/<id>/revisions.jsonl`).
+// The scenario is pinned to its digest, such that a revision can be rolled back to
+// even if the tag has moved since.
+type Revision struct {
+	Revision       int64             `json:"revision"`
+	Scenario       string            `json:"scenario"`
+	Additional     map[string]string `json:"additional,omitempty"`
+	Timeout        *time.Duration    `json:"timeout,omitempty"`
+	Until          *time.Time        `json:"until,omitempty"`
+	Min            int64             `json:"min"`
+	Max            int64             `json:"max"`
+	UpdateStrategy string            `json:"update_strategy,omitempty"`
+	At             time.Time         `json:"at"`
+}
+
+// AppendRevision appends the revision to the history of the challenge.
+// Its number is set to follow the last one recorded, starting at 1.
+// The caller must hold the challenge RW lock.
+func AppendRevision(challID string, rev *Revision) error {
+	last, err := LastRevision(challID)
+	if err != nil {
+		return err
+	}
+	rev.Revision = last + 1
+
+	b, err := json.Marshal(rev)
+	if err != nil {
+		return &errs.ErrInternal{Sub: err}
+	}
+	fpath := filepath.Join(ChallengeDirectory(challID), revisionsFile)
+	f, err := os.OpenFile(fpath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &errs.ErrChallengeExist{
+				ID:    challID,
+				Exist: false,
+			}
+		}
+		return &errs.ErrInternal{Sub: err}
+	}
+	defer fclose(f)
+
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		return &errs.ErrInternal{Sub: err}
+	}
+	return nil
+}
+
+// LoadRevisions returns the history of the challenge, from the oldest revision
+// to the latest.
+func LoadRevisions(challID string) ([]*Revision, error) {
+	fpath := filepath.Join(ChallengeDirectory(challID), revisionsFile)
+	f, err := os.Open(fpath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, &errs.ErrInternal{Sub: err}
+	}
+	defer fclose(f)
+
+	revs := []*Revision{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		rev := &Revision{}
+		if err := json.Unmarshal(scanner.Bytes(), rev); err != nil {
+			return nil, &errs.ErrInternal{Sub: err}
+		}
+		revs = append(revs, rev)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, &errs.ErrInternal{Sub: err}
+	}
+	return revs, nil
+}
+
+// LastRevision returns the number of the latest revision of the challenge,
+// or 0 if none was recorded.
+func LastRevision(challID string) (int64, error) {
+	revs, err := LoadRevisions(challID)
+	if err != nil {
+		return 0, err
+	}
+	if len(revs) == 0 {
+		return 0, nil
+	}
+	return revs[len(revs)-1].Revision, nil
+}
+
+// LoadRevision returns the given revision of the challenge, or an error if
+// it was never recorded.
+func LoadRevision(challID string, revision int64) (*Revision, error) {
+	revs, err := LoadRevisions(challID)
+	if err != nil {
+		return nil, err
+	}
+	for _, rev := range revs {
+		if rev.Revision == revision {
+			return rev, nil
+		}
+	}
+	return nil, &errs.ErrRevisionNotFound{
+		ChallengeID: challID,
+		Revision:    revision,
+	}
+}
@@ -0,0 +1,47 @@
+package fs_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ctfer-io/chall-manager/global"
+	errs "github.com/ctfer-io/chall-manager/pkg/errors"
+	"github.com/ctfer-io/chall-manager/pkg/fs"
+)
+
+func Test_U_Revisions(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	global.Conf.Directory = t.TempDir()
+	require.NoError(os.Mkdir(filepath.Join(global.Conf.Directory, "chall"), os.ModePerm))
+
+	fschall := &fs.Challenge{ID: "chall"}
+	require.NoError(fschall.Save())
+
+	// No history yet
+	revs, err := fs.LoadRevisions("chall")
+	require.NoError(err)
+	assert.Empty(revs)
+
+	// Revisions are numbered in order
+	for _, scn := range []string{"scn@sha256:a", "scn@sha256:b"} {
+		require.NoError(fs.AppendRevision("chall", &fs.Revision{Scenario: scn}))
+	}
+	revs, err = fs.LoadRevisions("chall")
+	require.NoError(err)
+	require.Len(revs, 2)
+	assert.Equal(int64(1), revs[0].Revision)
+	assert.Equal(int64(2), revs[1].Revision)
+
+	rev, err := fs.LoadRevision("chall", 1)
+	require.NoError(err)
+	assert.Equal("scn@sha256:a", rev.Scenario)
+
+	_, err = fs.LoadRevision("chall", 3)
+	assert.IsType(&errs.ErrRevisionNotFound{}, err)
+}
@@ -13,3 +13,20 @@ var _ error = (*ErrOutputRequired)(nil)
 func (err ErrOutputRequired) Error() string {
 	return fmt.Sprintf("state output %s is required, please update the Challenge Scenario to ensure export of it", err.Key)
 }
+
+// ErrNotReady is an error returned when an instance did not pass its readiness
+// gate in time.
+type ErrNotReady struct {
+	Identity string
+	Sub      error
+}
+
+var _ error = (*ErrNotReady)(nil)
+
+func (err ErrNotReady) Error() string {
+	return fmt.Sprintf("instance %s is not ready: %s", err.Identity, err.Sub)
+}
+
+func (err ErrNotReady) Unwrap() error {
+	return err.Sub
+}
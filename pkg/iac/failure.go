@@ -0,0 +1,49 @@
+package iac
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/ctfer-io/chall-manager/global"
+)
+
+// UpWithRetries runs up on the stack, retrying it on failure as configured
+// by the failure policy, with an exponential backoff.
+// Retries converge the resources the previous tries managed to create.
+func (stack *Stack) UpWithRetries(ctx context.Context) (*Result, error) {
+	backoff := global.Conf.UpBackoff
+	for try := int64(1); ; try++ {
+		res, err := stack.Up(ctx)
+		if err == nil || try > global.Conf.UpRetries {
+			return res, err
+		}
+
+		global.Log().Warn(ctx, "stack up failed, retrying",
+			zap.Int64("try", try),
+			zap.Duration("backoff", backoff),
+			zap.Error(err),
+		)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, err
+		}
+		backoff *= 2
+	}
+}
+
+// Cleanup destroys the resources a failed stack managed to create, then
+// removes it from its workspace.
+// If it could not, the stack is leaked and counted as such.
+func (stack *Stack) Cleanup(ctx context.Context) error {
+	err := stack.Down(ctx)
+	if err == nil {
+		err = stack.pas.Workspace().RemoveStack(ctx, stack.pas.Name())
+	}
+	if err != nil {
+		LeakedStacksCounter().Add(ctx, 1)
+	}
+	return err
+}
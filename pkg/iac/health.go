@@ -0,0 +1,188 @@
+package iac
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	fsapi "github.com/ctfer-io/chall-manager/pkg/fs"
+)
+
+// Checker probes whether an instance is ready to serve players.
+type Checker interface {
+	Check(ctx context.Context, fsist *fsapi.Instance) error
+}
+
+// TCPChecker checks a TCP connection can be established to the address found
+// in the instance connection information, e.g. `nc 10.0.0.1 1337`.
+type TCPChecker struct{}
+
+var _ Checker = (*TCPChecker)(nil)
+
+func (TCPChecker) Check(ctx context.Context, fsist *fsapi.Instance) error {
+	addr, err := findAddress(fsist.ConnectionInfo)
+	if err != nil {
+		return err
+	}
+	return dial(ctx, addr)
+}
+
+// HTTPChecker checks the URL found in the instance connection information
+// responds with the expected status, or any status below 400 if not set.
+type HTTPChecker struct {
+	Status int
+}
+
+var _ Checker = (*HTTPChecker)(nil)
+
+func (c HTTPChecker) Check(ctx context.Context, fsist *fsapi.Instance) error {
+	u, err := findURL(fsist.ConnectionInfo)
+	if err != nil {
+		return err
+	}
+	return get(ctx, u, c.Status)
+}
+
+// OutputChecker checks the `healthcheck` output exported by the scenario, either
+// a URL probed through HTTP, or an address probed through TCP.
+type OutputChecker struct{}
+
+var _ Checker = (*OutputChecker)(nil)
+
+func (OutputChecker) Check(ctx context.Context, fsist *fsapi.Instance) error {
+	out, ok := fsist.Outputs["healthcheck"]
+	if !ok {
+		return &ErrOutputRequired{Key: "healthcheck"}
+	}
+	hc, ok := out.Value.(string)
+	if !ok {
+		return fmt.Errorf("invalid healthcheck output type, should be a string")
+	}
+	if u, err := findURL(hc); err == nil {
+		return get(ctx, u, 0)
+	}
+	addr, err := findAddress(hc)
+	if err != nil {
+		return err
+	}
+	return dial(ctx, addr)
+}
+
+// Readiness gates an update: once up, the new instance must pass the check
+// before the timeout, probing it every interval.
+type Readiness struct {
+	Checker  Checker
+	Timeout  time.Duration
+	Interval time.Duration
+}
+
+type readinessKey struct{}
+
+// WithReadiness sets the readiness gate of the updates run with this context.
+func WithReadiness(ctx context.Context, r *Readiness) context.Context {
+	return context.WithValue(ctx, readinessKey{}, r)
+}
+
+func readinessOf(ctx context.Context) *Readiness {
+	r, _ := ctx.Value(readinessKey{}).(*Readiness)
+	return r
+}
+
+// Wait probes the instance until it passes the check, or returns the last
+// failure once the timeout is reached.
+func (r *Readiness) Wait(ctx context.Context, fsist *fsapi.Instance) error {
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+	for {
+		err := r.Checker.Check(ctx, fsist)
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return &ErrNotReady{
+				Identity: fsist.Identity,
+				Sub:      err,
+			}
+		case <-ticker.C:
+		}
+	}
+}
+
+func dial(ctx context.Context, addr string) error {
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func get(ctx context.Context, u string, status int) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = res.Body.Close()
+
+	if (status == 0 && res.StatusCode >= 400) || (status != 0 && res.StatusCode != status) {
+		return fmt.Errorf("unexpected status %d", res.StatusCode)
+	}
+	return nil
+}
+
+// findURL returns the first HTTP(S) URL in s.
+func findURL(s string) (string, error) {
+	for _, f := range strings.Fields(s) {
+		u, err := url.Parse(f)
+		if err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" {
+			return f, nil
+		}
+	}
+	return "", errors.New("no URL found")
+}
+
+// findAddress returns the first TCP address in s, either as `host:port`,
+// `host port` (e.g. a netcat command) or an URL.
+func findAddress(s string) (string, error) {
+	fields := strings.Fields(s)
+	for i, f := range fields {
+		if u, err := url.Parse(f); err == nil && u.Scheme != "" && u.Host != "" {
+			port := u.Port()
+			switch {
+			case port != "":
+			case u.Scheme == "https":
+				port = "443"
+			case u.Scheme == "http":
+				port = "80"
+			default:
+				continue
+			}
+			return net.JoinHostPort(u.Hostname(), port), nil
+		}
+		if _, port, err := net.SplitHostPort(f); err == nil && isPort(port) {
+			return f, nil
+		}
+		if i+1 < len(fields) && isPort(fields[i+1]) && !isPort(f) {
+			return net.JoinHostPort(f, fields[i+1]), nil
+		}
+	}
+	return "", errors.New("no address found")
+}
+
+func isPort(s string) bool {
+	p, err := strconv.Atoi(s)
+	return err == nil && p > 0 && p < 65536
+}
@@ -0,0 +1,144 @@
+package iac
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	fsapi "github.com/ctfer-io/chall-manager/pkg/fs"
+)
+
+func Test_U_Checkers(t *testing.T) {
+	t.Parallel()
+
+	// A TCP listener, and an address nothing listens on anymore
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = lis.Close() })
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.Close()
+		}
+	}()
+	host, port, _ := net.SplitHostPort(lis.Addr().String())
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	closedAddr := closed.Addr().String()
+	require.NoError(t, closed.Close())
+
+	// An HTTP server that is healthy on /, not on /down
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/down" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	var tests = map[string]struct {
+		Checker   Checker
+		Instance  *fsapi.Instance
+		ExpectErr bool
+	}{
+		"tcp-netcat": {
+			Checker:  TCPChecker{},
+			Instance: &fsapi.Instance{ConnectionInfo: fmt.Sprintf("nc %s %s", host, port)},
+		},
+		"tcp-address": {
+			Checker:  TCPChecker{},
+			Instance: &fsapi.Instance{ConnectionInfo: lis.Addr().String()},
+		},
+		"tcp-closed": {
+			Checker:   TCPChecker{},
+			Instance:  &fsapi.Instance{ConnectionInfo: closedAddr},
+			ExpectErr: true,
+		},
+		"tcp-no-address": {
+			Checker:   TCPChecker{},
+			Instance:  &fsapi.Instance{ConnectionInfo: "ask an admin"},
+			ExpectErr: true,
+		},
+		"http-healthy": {
+			Checker:  HTTPChecker{},
+			Instance: &fsapi.Instance{ConnectionInfo: "Browse " + srv.URL},
+		},
+		"http-unhealthy": {
+			Checker:   HTTPChecker{},
+			Instance:  &fsapi.Instance{ConnectionInfo: srv.URL + "/down"},
+			ExpectErr: true,
+		},
+		"http-expected-status": {
+			Checker:  HTTPChecker{Status: http.StatusServiceUnavailable},
+			Instance: &fsapi.Instance{ConnectionInfo: srv.URL + "/down"},
+		},
+		"output-url": {
+			Checker: OutputChecker{},
+			Instance: &fsapi.Instance{Outputs: fsapi.Outputs{
+				"healthcheck": {Value: srv.URL},
+			}},
+		},
+		"output-address": {
+			Checker: OutputChecker{},
+			Instance: &fsapi.Instance{Outputs: fsapi.Outputs{
+				"healthcheck": {Value: lis.Addr().String()},
+			}},
+		},
+		"output-missing": {
+			Checker:   OutputChecker{},
+			Instance:  &fsapi.Instance{},
+			ExpectErr: true,
+		},
+	}
+
+	for testname, tt := range tests {
+		t.Run(testname, func(t *testing.T) {
+			assert := assert.New(t)
+
+			err := tt.Checker.Check(context.Background(), tt.Instance)
+			if tt.ExpectErr {
+				assert.Error(err)
+			} else {
+				assert.NoError(err)
+			}
+		})
+	}
+}
+
+func Test_U_ReadinessWait(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := lis.Addr().String()
+	require.NoError(t, lis.Close())
+
+	r := &Readiness{
+		Checker:  TCPChecker{},
+		Timeout:  200 * time.Millisecond,
+		Interval: 10 * time.Millisecond,
+	}
+	fsist := &fsapi.Instance{Identity: "id", ConnectionInfo: addr}
+
+	// Nothing listens, the gate does not pass
+	err = r.Wait(context.Background(), fsist)
+	assert.IsType(&ErrNotReady{}, err)
+
+	// Once listening, it passes
+	lis, err = net.Listen("tcp", addr)
+	require.NoError(t, err)
+	defer func() { _ = lis.Close() }()
+	assert.NoError(r.Wait(context.Background(), fsist))
+}
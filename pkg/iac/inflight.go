@@ -0,0 +1,103 @@
+package iac
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrOperationCanceled is the cause of the cancelation of in-flight stack
+// operations.
+var ErrOperationCanceled = errors.New("operation canceled")
+
+type inflightOp struct {
+	cancel context.CancelCauseFunc
+	done   chan struct{}
+}
+
+var (
+	inflight   = map[string]map[string]*inflightOp{}
+	inflightMx sync.Mutex
+)
+
+// Track the stack operations run with the returned context as in-flight for
+// the instance, such that they can be canceled. The caller must call done once
+// they are over, cleanup included.
+//
+// Canceling the context makes the Pulumi automation API interrupt the engine
+// gracefully, as `pulumi cancel` is only supported by the Pulumi Cloud backend.
+// Operations are tracked by replica.
+func Track(ctx context.Context, challID, identity string) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	op := &inflightOp{
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	inflightMx.Lock()
+	ops, ok := inflight[challID]
+	if !ok {
+		ops = map[string]*inflightOp{}
+		inflight[challID] = ops
+	}
+	ops[identity] = op
+	inflightMx.Unlock()
+
+	return ctx, func() {
+		inflightMx.Lock()
+		if ops[identity] == op {
+			delete(ops, identity)
+		}
+		if len(ops) == 0 {
+			delete(inflight, challID)
+		}
+		inflightMx.Unlock()
+
+		cancel(nil)
+		close(op.done)
+	}
+}
+
+// Cancel the in-flight operations of an instance, and wait for them to be
+// over. It returns whether there was one.
+func Cancel(ctx context.Context, challID, identity string) (bool, error) {
+	inflightMx.Lock()
+	op, ok := inflight[challID][identity]
+	inflightMx.Unlock()
+	if !ok {
+		return false, nil
+	}
+	return true, wait(ctx, op)
+}
+
+// CancelChallenge cancels the in-flight operations of all the instances of a
+// challenge, and wait for them to be over. It returns the number of canceled
+// operations.
+func CancelChallenge(ctx context.Context, challID string) (int, error) {
+	inflightMx.Lock()
+	ops := make([]*inflightOp, 0, len(inflight[challID]))
+	for _, op := range inflight[challID] {
+		ops = append(ops, op)
+	}
+	inflightMx.Unlock()
+
+	for _, op := range ops {
+		op.cancel(ErrOperationCanceled)
+	}
+	for _, op := range ops {
+		if err := wait(ctx, op); err != nil {
+			return 0, err
+		}
+	}
+	return len(ops), nil
+}
+
+func wait(ctx context.Context, op *inflightOp) error {
+	op.cancel(ErrOperationCanceled)
+	select {
+	case <-op.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
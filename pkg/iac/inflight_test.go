@@ -0,0 +1,61 @@
+package iac
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_U_Cancel(t *testing.T) {
+	t.Parallel()
+
+	// Nothing to cancel
+	ok, err := Cancel(t.Context(), "chall-cancel", "a")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	// Operations are canceled with the proper cause, and waited for
+	ctx, done := Track(context.Background(), "chall-cancel", "a")
+	stopped := false
+	go func() {
+		<-ctx.Done()
+		time.Sleep(10 * time.Millisecond) // e.g. the engine stopping gracefully
+		stopped = true
+		done()
+	}()
+
+	ok, err = Cancel(t.Context(), "chall-cancel", "a")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.True(t, stopped)
+	assert.ErrorIs(t, context.Cause(ctx), ErrOperationCanceled)
+
+	// Once done, it is no longer in-flight
+	ok, err = Cancel(t.Context(), "chall-cancel", "a")
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func Test_U_CancelChallenge(t *testing.T) {
+	t.Parallel()
+
+	ctxs := []context.Context{}
+	for _, id := range []string{"a", "b"} {
+		ctx, done := Track(context.Background(), "chall-cancel-all", id)
+		go func() {
+			<-ctx.Done()
+			done()
+		}()
+		ctxs = append(ctxs, ctx)
+	}
+
+	n, err := CancelChallenge(t.Context(), "chall-cancel-all")
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	for _, ctx := range ctxs {
+		assert.ErrorIs(t, context.Cause(ctx), ErrOperationCanceled)
+	}
+}
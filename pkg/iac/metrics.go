@@ -0,0 +1,60 @@
+package iac
+
+import (
+	"sync"
+
+	"go.opentelemetry.io/otel/metric"
+
+	"github.com/ctfer-io/chall-manager/global"
+)
+
+var (
+	queueUDCounter     metric.Int64UpDownCounter
+	queueUDCounterOnce sync.Once
+
+	queueWaitHistogram     metric.Float64Histogram
+	queueWaitHistogramOnce sync.Once
+
+	leakedStacksCounter     metric.Int64Counter
+	leakedStacksCounterOnce sync.Once
+)
+
+func QueueUDCounter() metric.Int64UpDownCounter {
+	queueUDCounterOnce.Do(func() {
+		cnt, err := global.Meter.Int64UpDownCounter("stack_operations_queued",
+			metric.WithDescription("The number of stack operations waiting for a slot to run"),
+		)
+		if err != nil {
+			panic(err)
+		}
+		queueUDCounter = cnt
+	})
+	return queueUDCounter
+}
+
+func QueueWaitHistogram() metric.Float64Histogram {
+	queueWaitHistogramOnce.Do(func() {
+		hist, err := global.Meter.Float64Histogram("stack_operations_wait",
+			metric.WithDescription("The time stack operations waited for a slot to run"),
+			metric.WithUnit("s"),
+		)
+		if err != nil {
+			panic(err)
+		}
+		queueWaitHistogram = hist
+	})
+	return queueWaitHistogram
+}
+
+func LeakedStacksCounter() metric.Int64Counter {
+	leakedStacksCounterOnce.Do(func() {
+		cnt, err := global.Meter.Int64Counter("leaked_stacks",
+			metric.WithDescription("The number of failed stacks that could not be destroyed, thus whose resources may leak"),
+		)
+		if err != nil {
+			panic(err)
+		}
+		leakedStacksCounter = cnt
+	})
+	return leakedStacksCounter
+}
@@ -0,0 +1,76 @@
+package iac
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/ctfer-io/chall-manager/global"
+	fsapi "github.com/ctfer-io/chall-manager/pkg/fs"
+)
+
+// MaxOutputSize is the maximum size of the engine output kept per stack
+// operation. Once exceeded, the oldest output is dropped as the diagnostics
+// of a failure are at its end.
+const MaxOutputSize = 64 * 1024
+
+// output captures the engine output of a stack operation, up to MaxOutputSize.
+type output struct {
+	mx        sync.Mutex
+	operation string
+	at        time.Time
+	buf       []byte
+	truncated bool
+}
+
+var _ io.Writer = (*output)(nil)
+
+// reset prepares the capture of a new operation.
+func (out *output) reset(operation string) {
+	out.mx.Lock()
+	defer out.mx.Unlock()
+
+	out.operation = operation
+	out.at = time.Now()
+	out.buf = nil
+	out.truncated = false
+}
+
+func (out *output) Write(p []byte) (int, error) {
+	out.mx.Lock()
+	defer out.mx.Unlock()
+
+	out.buf = append(out.buf, p...)
+	if over := len(out.buf) - MaxOutputSize; over > 0 {
+		out.buf = out.buf[over:]
+		out.truncated = true
+	}
+	return len(p), nil
+}
+
+// SaveOutput stores the engine output of the last stack operation in the sink,
+// e.g. alongside the instance, best effort.
+func (stack *Stack) SaveOutput(ctx context.Context, sink fsapi.OperationLogSink, opErr error) {
+	stack.out.mx.Lock()
+	log := &fsapi.OperationLog{
+		Operation: stack.out.operation,
+		At:        stack.out.at,
+		Output:    string(stack.out.buf),
+		Truncated: stack.out.truncated,
+	}
+	stack.out.mx.Unlock()
+
+	if log.Operation == "" {
+		// No operation ran
+		return
+	}
+	if opErr != nil {
+		log.Error = opErr.Error()
+	}
+	if err := sink.SaveOperationLog(log); err != nil {
+		global.Log().Error(ctx, "saving stack operation output", zap.Error(err))
+	}
+}
@@ -0,0 +1,34 @@
+package iac
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_U_Output(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+
+	out := &output{}
+	out.reset("up")
+
+	// Under the limit, everything is kept
+	_, _ = out.Write([]byte("start\n"))
+	assert.Equal("start\n", string(out.buf))
+	assert.False(out.truncated)
+
+	// Over the limit, only the end is kept
+	_, _ = out.Write(bytes.Repeat([]byte("a"), MaxOutputSize))
+	_, _ = out.Write([]byte("error: boom\n"))
+	assert.Len(out.buf, MaxOutputSize)
+	assert.True(bytes.HasSuffix(out.buf, []byte("error: boom\n")))
+	assert.True(out.truncated)
+
+	// A new operation starts from scratch
+	out.reset("down")
+	assert.Empty(out.buf)
+	assert.False(out.truncated)
+}
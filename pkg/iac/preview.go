@@ -0,0 +1,119 @@
+package iac
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/auto"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/apitype"
+	"go.uber.org/zap"
+
+	"github.com/ctfer-io/chall-manager/global"
+	errs "github.com/ctfer-io/chall-manager/pkg/errors"
+	fsapi "github.com/ctfer-io/chall-manager/pkg/fs"
+)
+
+// Diff summarizes the changes a stack operation would do on resources.
+type Diff struct {
+	Creates  int64
+	Updates  int64
+	Replaces int64
+	Deletes  int64
+	Sames    int64
+}
+
+func newDiff(summary map[apitype.OpType]int) *Diff {
+	return &Diff{
+		Creates:  int64(summary[apitype.OpCreate]),
+		Updates:  int64(summary[apitype.OpUpdate]),
+		Replaces: int64(summary[apitype.OpReplace]),
+		Deletes:  int64(summary[apitype.OpDelete]),
+		Sames:    int64(summary[apitype.OpSame]),
+	}
+}
+
+// PreviewCreate previews the creation of an instance of the challenge with
+// the given additional values, without spinning it up.
+func PreviewCreate(ctx context.Context, fschall *fsapi.Challenge, additional map[string]string) (*Diff, error) {
+	ctx, span := global.Tracer.Start(ctx, "previewing-creation")
+	defer span.End()
+
+	id := randName()
+	stack, err := LoadStack(ctx, fschall.Scenario, id)
+	if err != nil {
+		return nil, err
+	}
+	// The stack only exists for the preview, it holds no resource
+	defer func() {
+		if err := stack.pas.Workspace().RemoveStack(context.WithoutCancel(ctx), stack.pas.Name()); err != nil {
+			global.Log().Error(ctx, "removing preview stack", zap.Error(err))
+		}
+	}()
+	if err := stack.pas.SetConfig(ctx, "identity", auto.ConfigValue{Value: id}); err != nil {
+		return nil, &errs.ErrInternal{Sub: err}
+	}
+	if err := Additional(ctx, stack, fschall.Additional, additional); err != nil {
+		return nil, &errs.ErrInternal{Sub: err}
+	}
+	return stack.Preview(ctx)
+}
+
+// PreviewUpdate previews the update of an instance to the challenge scenario
+// and additional values given an update strategy, without changing anything.
+// Strategies that spin up a new instance then destroy the existing one preview
+// the creation, and count all existing resources as deleted.
+func PreviewUpdate(ctx context.Context, updateStrategy string, fschall *fsapi.Challenge, fsist *fsapi.Instance) (*Diff, error) {
+	ctx, span := global.Tracer.Start(ctx, "previewing-update")
+	defer span.End()
+
+	switch updateStrategy {
+	case "blue_green", "recreate":
+		diff, err := PreviewCreate(ctx, fschall, fsist.Additional)
+		if err != nil {
+			return nil, err
+		}
+		diff.Deletes += countResources(fsist.State)
+		return diff, nil
+	}
+
+	stack, err := LoadStack(ctx, fschall.Scenario, fsist.Identity)
+	if err != nil {
+		return nil, err
+	}
+	if err := stack.pas.SetConfig(ctx, "identity", auto.ConfigValue{Value: fsist.Identity}); err != nil {
+		return nil, &errs.ErrInternal{Sub: err}
+	}
+	if err := Additional(ctx, stack, fschall.Additional, fsist.Additional); err != nil {
+		return nil, &errs.ErrInternal{Sub: err}
+	}
+	if fsist.State != nil {
+		if err := stack.Import(ctx, fsist); err != nil {
+			return nil, &errs.ErrInternal{Sub: err}
+		}
+	}
+	return stack.Preview(ctx)
+}
+
+// countResources returns the number of resources in a stack state, the stack
+// itself excluded.
+func countResources(state any) int64 {
+	b, err := json.Marshal(state)
+	if err != nil {
+		return 0
+	}
+	var dep struct {
+		Resources []struct {
+			Type string `json:"type"`
+		} `json:"resources"`
+	}
+	if err := json.Unmarshal(b, &dep); err != nil {
+		return 0
+	}
+	var count int64
+	for _, res := range dep.Resources {
+		if res.Type != "pulumi:pulumi:Stack" {
+			count++
+		}
+	}
+	return count
+}
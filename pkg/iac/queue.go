@@ -0,0 +1,151 @@
+package iac
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	"github.com/ctfer-io/chall-manager/global"
+)
+
+// Priority of a stack operation in the queue.
+// Operations of higher priority are run first, then in FIFO order.
+type Priority int
+
+const (
+	// PriorityLow is for background operations, e.g. pool refills or update rollouts.
+	PriorityLow Priority = iota
+	// PriorityNormal is the default priority.
+	PriorityNormal
+	// PriorityHigh is for player-triggered creations.
+	PriorityHigh
+
+	nPriorities
+)
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityLow:
+		return "low"
+	case PriorityHigh:
+		return "high"
+	default:
+		return "normal"
+	}
+}
+
+type priorityKey struct{}
+
+// WithPriority sets the priority of the stack operations run with this context.
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+func priorityOf(ctx context.Context) Priority {
+	if p, ok := ctx.Value(priorityKey{}).(Priority); ok && p >= 0 && p < nPriorities {
+		return p
+	}
+	return PriorityNormal
+}
+
+// queue is the admission control of stack operations, bounding how many
+// Pulumi engines run concurrently.
+type queue struct {
+	mx      sync.Mutex
+	running int
+	waiting [nPriorities][]chan struct{}
+}
+
+var q = &queue{}
+
+// acquire a slot to run a stack operation, waiting for one if the concurrency
+// limit is reached. The returned function must be called to release the slot.
+func acquire(ctx context.Context) (func(), error) {
+	limit := global.Conf.MaxConcurrentStacks
+	prio := priorityOf(ctx)
+	attrs := metric.WithAttributeSet(attribute.NewSet(
+		attribute.String("priority", prio.String()),
+	))
+	start := time.Now()
+
+	q.mx.Lock()
+	if limit == 0 || (q.running < int(limit) && q.empty()) {
+		q.running++
+		q.mx.Unlock()
+
+		QueueWaitHistogram().Record(ctx, 0, attrs)
+		return q.release, nil
+	}
+	ch := make(chan struct{}, 1)
+	q.waiting[prio] = append(q.waiting[prio], ch)
+	q.mx.Unlock()
+
+	QueueUDCounter().Add(ctx, 1, attrs)
+	defer QueueUDCounter().Add(ctx, -1, attrs)
+
+	select {
+	case <-ch:
+		QueueWaitHistogram().Record(ctx, time.Since(start).Seconds(), attrs)
+		return q.release, nil
+
+	case <-ctx.Done():
+		q.mx.Lock()
+		defer q.mx.Unlock()
+
+		// If the slot has been granted meanwhile, give it to the next one
+		select {
+		case <-ch:
+			q.running--
+			q.next()
+		default:
+			q.remove(prio, ch)
+		}
+		return nil, ctx.Err()
+	}
+}
+
+func (q *queue) release() {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+
+	q.running--
+	q.next()
+}
+
+// next grants a slot to the first waiting operation of highest priority, if any.
+// It must be called with the mutex held.
+func (q *queue) next() {
+	limit := global.Conf.MaxConcurrentStacks
+	for p := nPriorities - 1; p >= 0; p-- {
+		for len(q.waiting[p]) != 0 {
+			if limit != 0 && q.running >= int(limit) {
+				return
+			}
+			ch := q.waiting[p][0]
+			q.waiting[p] = q.waiting[p][1:]
+			q.running++
+			ch <- struct{}{}
+		}
+	}
+}
+
+func (q *queue) empty() bool {
+	for _, w := range q.waiting {
+		if len(w) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (q *queue) remove(prio Priority, ch chan struct{}) {
+	for i, c := range q.waiting[prio] {
+		if c == ch {
+			q.waiting[prio] = append(q.waiting[prio][:i], q.waiting[prio][i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package iac
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ctfer-io/chall-manager/global"
+)
+
+func Test_U_Queue(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	global.Conf.MaxConcurrentStacks = 1
+	defer func() {
+		global.Conf.MaxConcurrentStacks = 0
+	}()
+
+	// Fill the only slot
+	release, err := acquire(context.Background())
+	require.NoError(err)
+
+	// Queue operations, the canceled one must leave the queue
+	order := make(chan Priority, 3)
+	enqueue := func(ctx context.Context, p Priority) {
+		go func() {
+			release, err := acquire(WithPriority(ctx, p))
+			if err != nil {
+				return
+			}
+			order <- p
+			release()
+		}()
+		require.Eventually(func() bool {
+			q.mx.Lock()
+			defer q.mx.Unlock()
+			return len(q.waiting[p]) != 0
+		}, time.Second, time.Millisecond)
+	}
+	canceled, cancel := context.WithCancel(context.Background())
+	enqueue(canceled, PriorityHigh)
+	cancel()
+	require.Eventually(func() bool {
+		q.mx.Lock()
+		defer q.mx.Unlock()
+		return len(q.waiting[PriorityHigh]) == 0
+	}, time.Second, time.Millisecond)
+	enqueue(context.Background(), PriorityLow)
+	enqueue(context.Background(), PriorityNormal)
+	enqueue(context.Background(), PriorityHigh)
+
+	// Once released, higher priorities go first
+	release()
+	assert.Equal(PriorityHigh, <-order)
+	assert.Equal(PriorityNormal, <-order)
+	assert.Equal(PriorityLow, <-order)
+
+	assert.Eventually(func() bool {
+		q.mx.Lock()
+		defer q.mx.Unlock()
+		return q.running == 0 && q.empty()
+	}, time.Second, time.Millisecond)
+}
@@ -0,0 +1,66 @@
+package iac
+
+import (
+	"context"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/auto"
+	"go.uber.org/zap"
+
+	"github.com/ctfer-io/chall-manager/global"
+	errs "github.com/ctfer-io/chall-manager/pkg/errors"
+	"github.com/ctfer-io/chall-manager/pkg/fs"
+)
+
+// Drifted returns whether the refresh found resources that changed or
+// disappeared since the last operation.
+func (diff *Diff) Drifted() bool {
+	return diff.Updates+diff.Replaces+diff.Deletes != 0
+}
+
+// Refresh the state of an instance from its actual resources, to detect
+// drift e.g. when players mutate or delete them from inside the instance.
+// The refreshed state is exported into the instance, it is up to the caller
+// to save it.
+func Refresh(ctx context.Context, fschall *fs.Challenge, fsist *fs.Instance) (*Diff, error) {
+	ctx, span := global.Tracer.Start(ctx, "refreshing-instance")
+	defer span.End()
+
+	stack, err := LoadStack(ctx, fsist.RunningScenario(fschall.Scenario), fsist.Identity)
+	if err != nil {
+		return nil, err
+	}
+	if err := stack.pas.SetConfig(ctx, "identity", auto.ConfigValue{Value: fsist.Identity}); err != nil {
+		return nil, &errs.ErrInternal{Sub: err}
+	}
+	if err := Additional(ctx, stack, fschall.Additional, fsist.Additional); err != nil {
+		return nil, &errs.ErrInternal{Sub: err}
+	}
+	if fsist.State != nil {
+		if err := stack.Import(ctx, fsist); err != nil {
+			return nil, &errs.ErrInternal{Sub: err}
+		}
+	}
+
+	diff, err := stack.Refresh(ctx)
+	stack.SaveOutput(ctx, fsist, err)
+	if err != nil {
+		return nil, err
+	}
+
+	udp, err := stack.pas.Export(ctx)
+	if err != nil {
+		return nil, &errs.ErrInternal{Sub: err}
+	}
+	fsist.State = udp.Deployment
+	return diff, nil
+}
+
+// Repair an instance that drifted, by running up again for its resources to
+// converge back to the scenario.
+// It should be called after Refresh, else the drift remains unknown to the stack.
+// The instance keeps running its scenario, e.g. if left behind by a deferred update.
+func Repair(ctx context.Context, fschall *fs.Challenge, fsist *fs.Instance) error {
+	global.Log().Info(ctx, "repairing instance", zap.String("instance", fsist.Identity))
+
+	return up(ctx, fsist.RunningScenario(fschall.Scenario), fsist.Identity, fschall, fsist)
+}
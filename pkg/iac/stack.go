@@ -9,6 +9,10 @@ import (
 
 	"github.com/pkg/errors"
 	"github.com/pulumi/pulumi/sdk/v3/go/auto"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto/optdestroy"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto/optpreview"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto/optrefresh"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto/optup"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/apitype"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/workspace"
 	"gopkg.in/yaml.v3"
@@ -21,6 +25,8 @@ import (
 type Stack struct {
 	// pulumi auto stack
 	pas auto.Stack
+	// engine output of the last operation
+	out *output
 }
 
 func NewStack(ctx context.Context, fschall *fsapi.Challenge, id string) (*Stack, error) {
@@ -44,6 +50,14 @@ func LoadStack(ctx context.Context, scenario, id string) (*Stack, error) {
 	ctx, span := global.Tracer.Start(ctx, "loading-stack")
 	defer span.End()
 
+	// Pulling the scenario and building its workspace (e.g. compiling a Go
+	// program) is as heavy as an operation on it, thus is queued the same way
+	release, err := acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
 	// Load the scenario
 	dir, err := global.GetOCIManager().Load(ctx, scenario)
 	if err != nil {
@@ -81,6 +95,7 @@ func LoadStack(ctx context.Context, scenario, id string) (*Stack, error) {
 	}
 	return &Stack{
 		pas: pas,
+		out: &output{},
 	}, nil
 }
 
@@ -112,7 +127,17 @@ type Result struct {
 }
 
 func (stack *Stack) Up(ctx context.Context) (*Result, error) {
-	res, err := stack.pas.Up(ctx)
+	release, err := acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	stack.out.reset("up")
+	res, err := stack.pas.Up(ctx,
+		optup.ProgressStreams(stack.out),
+		optup.ErrorProgressStreams(stack.out),
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -121,13 +146,64 @@ func (stack *Stack) Up(ctx context.Context) (*Result, error) {
 	}, nil
 }
 
-func (stack *Stack) Preview(ctx context.Context) error {
-	_, err := stack.pas.Preview(ctx)
-	return err
+// Preview the stack operation without changing anything, and summarize the
+// changes it would do.
+func (stack *Stack) Preview(ctx context.Context) (*Diff, error) {
+	release, err := acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	stack.out.reset("preview")
+	res, err := stack.pas.Preview(ctx,
+		optpreview.ProgressStreams(stack.out),
+		optpreview.ErrorProgressStreams(stack.out),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return newDiff(res.ChangeSummary), nil
+}
+
+// Refresh the stack state from the actual resources, and summarize the
+// differences found with the previous state.
+func (stack *Stack) Refresh(ctx context.Context) (*Diff, error) {
+	release, err := acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	stack.out.reset("refresh")
+	res, err := stack.pas.Refresh(ctx,
+		optrefresh.ProgressStreams(stack.out),
+		optrefresh.ErrorProgressStreams(stack.out),
+	)
+	if err != nil {
+		return nil, err
+	}
+	changes := map[apitype.OpType]int{}
+	if res.Summary.ResourceChanges != nil {
+		for op, n := range *res.Summary.ResourceChanges {
+			changes[apitype.OpType(op)] = n
+		}
+	}
+	return newDiff(changes), nil
 }
 
 func (stack *Stack) Down(ctx context.Context) error {
-	_, err := stack.pas.Destroy(ctx)
+	release, err := acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	stack.out.reset("down")
+	_, err = stack.pas.Destroy(ctx,
+		optdestroy.ProgressStreams(stack.out),
+		optdestroy.ErrorProgressStreams(stack.out),
+	)
 	return err
 }
 
@@ -165,12 +241,52 @@ func (stack *Stack) Export(ctx context.Context, res *Result, ist *fsapi.Instance
 		}
 	}
 
+	var outs fsapi.Outputs
+	if o, ok := res.sub.Outputs["outputs"]; ok {
+		outs, err = parseOutputs(o.Value)
+		if err != nil {
+			return err
+		}
+	}
+
 	ist.State = udp.Deployment
 	ist.ConnectionInfo = coninfo.Value.(string)
 	ist.Flags = flags
+	ist.Outputs = outs
 	return nil
 }
 
+// parseOutputs extracts the typed outputs from the "outputs" stack export.
+// Entries are either exported by the SDK as a {"value", "secret"} object, or
+// are plain values (e.g. scenarios that don't use the SDK), considered public.
+func parseOutputs(v any) (fsapi.Outputs, error) {
+	m, ok := v.(map[string]any)
+	if !ok {
+		return nil, &errs.ErrInternal{Sub: fmt.Errorf("invalid outputs type, should be an object")}
+	}
+
+	outs := make(fsapi.Outputs, len(m))
+	for k, v := range m {
+		out := fsapi.Output{
+			Value: v,
+		}
+		if obj, ok := v.(map[string]any); ok {
+			if val, ok := obj["value"]; ok {
+				out.Value = val
+				if sec, ok := obj["secret"]; ok {
+					b, ok := sec.(bool)
+					if !ok {
+						return nil, &errs.ErrInternal{Sub: fmt.Errorf("invalid secret type for output %s, should be a boolean", k)}
+					}
+					out.Secret = b
+				}
+			}
+		}
+		outs[k] = out
+	}
+	return outs, nil
+}
+
 func (stack *Stack) Import(ctx context.Context, ist *fsapi.Instance) error {
 	s, err := json.Marshal(ist.State)
 	if err != nil {
@@ -0,0 +1,74 @@
+package iac
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ctfer-io/chall-manager/pkg/fs"
+)
+
+func Test_U_ParseOutputs(t *testing.T) {
+	t.Parallel()
+
+	var tests = map[string]struct {
+		Value     any
+		Expected  fs.Outputs
+		ExpectErr bool
+	}{
+		"sdk-outputs": {
+			Value: map[string]any{
+				"endpoints": map[string]any{
+					"value": []any{"a.ctf.lan", "b.ctf.lan"},
+				},
+				"password": map[string]any{
+					"value":  "s3cr3t",
+					"secret": true,
+				},
+			},
+			Expected: fs.Outputs{
+				"endpoints": {Value: []any{"a.ctf.lan", "b.ctf.lan"}},
+				"password":  {Value: "s3cr3t", Secret: true},
+			},
+		},
+		"plain-outputs": {
+			Value: map[string]any{
+				"port": float64(22),
+				"user": map[string]any{
+					"name": "ctfer",
+				},
+			},
+			Expected: fs.Outputs{
+				"port": {Value: float64(22)},
+				"user": {Value: map[string]any{"name": "ctfer"}},
+			},
+		},
+		"invalid-outputs": {
+			Value:     "not an object",
+			ExpectErr: true,
+		},
+		"invalid-secret": {
+			Value: map[string]any{
+				"password": map[string]any{
+					"value":  "s3cr3t",
+					"secret": "yes",
+				},
+			},
+			ExpectErr: true,
+		},
+	}
+
+	for testname, tt := range tests {
+		t.Run(testname, func(t *testing.T) {
+			t.Parallel()
+
+			outs, err := parseOutputs(tt.Value)
+			if tt.ExpectErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.Expected, outs)
+		})
+	}
+}
@@ -16,18 +16,41 @@ import (
 // Update a challenge instance given an update strategy.
 // You need to give the previous scenario it should be updated from in order for
 // the update strategy to properly resolve the resources' delta.
+// On success, the instance runs the challenge revision.
 func Update(
 	ctx context.Context,
 	previousScenario string,
 	updateStrategy string,
 	fschall *fs.Challenge,
 	fsist *fs.Instance,
+) error {
+	if err := update(ctx, previousScenario, updateStrategy, fschall, fsist); err != nil {
+		return err
+	}
+	fsist.Revision = fschall.Revision
+	return nil
+}
+
+func update(
+	ctx context.Context,
+	previousScenario string,
+	updateStrategy string,
+	fschall *fs.Challenge,
+	fsist *fs.Instance,
 ) error {
 	switch updateStrategy {
 	// default value such that pool claim is possible (elseway cyclic imports)
 	case "update_in_place", "":
 		return updateInPlace(ctx, previousScenario, fschall, fsist)
 
+	// canaries, then the other instances, are updated in place
+	case "canary":
+		return updateInPlace(ctx, previousScenario, fschall, fsist)
+
+	// instances are updated in place once migrated
+	case "deferred":
+		return updateInPlace(ctx, previousScenario, fschall, fsist)
+
 	case "blue_green":
 		return blueGreen(ctx, previousScenario, fschall, fsist)
 
@@ -39,27 +62,62 @@ func Update(
 
 // Update-In-Place strategy loads the existing stack and state then moves to the
 // new stack and update the state.
-func updateInPlace(ctx context.Context, previousScenario string, fschall *fs.Challenge, fsist *fs.Instance) error {
-	return up(ctx, previousScenario, fsist.Identity, fschall, fsist)
+func updateInPlace(ctx context.Context, _ string, fschall *fs.Challenge, fsist *fs.Instance) error {
+	return up(ctx, fschall.Scenario, fsist.Identity, fschall, fsist)
 }
 
 // Blue Green deployment spins up a new instance and once it's done destroys the existing one.
+// If a readiness gate is set in the context, the new instance must pass it first, else
+// it is destroyed and the existing one is kept.
 func blueGreen(ctx context.Context, previousScenario string, fschall *fs.Challenge, fsist *fs.Instance) error {
-	oldID := fsist.Identity
+	old := *fsist
 	fsist.Identity = identity.New()
 
-	if err := up(ctx, previousScenario, fsist.Identity, fschall, fsist); err != nil {
+	if err := up(ctx, fschall.Scenario, fsist.Identity, fschall, fsist); err != nil {
 		return err
 	}
-	return down(ctx, previousScenario, oldID, fschall, fsist)
+	if r := readinessOf(ctx); r != nil {
+		if err := r.Wait(ctx, fsist); err != nil {
+			global.Log().Error(ctx, "new instance is not ready, keeping the existing one",
+				zap.String("instance", fsist.Identity),
+				zap.Error(err),
+			)
+			if derr := down(ctx, fschall.Scenario, fsist.Identity, fschall, fsist); derr != nil {
+				global.Log().Error(ctx, "destroying unready instance", zap.Error(derr))
+			}
+			*fsist = old
+			return err
+		}
+	}
+	return down(ctx, previousScenario, old.Identity, fschall, fsist)
 }
 
 // Recreate destroys the existing instance then spins up a new one.
 func recreate(ctx context.Context, previousScenario string, fschall *fs.Challenge, fsist *fs.Instance) error {
+	return recreateAs(ctx, previousScenario, fschall.Scenario, fsist.Identity, fschall, fsist)
+}
+
+// recreateAs destroys the existing instance then spins up a new one of the given
+// scenario with the given identity.
+func recreateAs(ctx context.Context, previousScenario, scenario, id string, fschall *fs.Challenge, fsist *fs.Instance) error {
 	if err := down(ctx, previousScenario, fsist.Identity, fschall, fsist); err != nil {
 		return err
 	}
-	return up(ctx, fschall.Scenario, fsist.Identity, fschall, fsist)
+	fsist.Identity = id
+	return up(ctx, scenario, id, fschall, fsist)
+}
+
+// Reset a challenge instance from scratch, using the recreate strategy.
+// If newIdentity is set, the instance gets a new identity e.g. for its flags to change.
+// The instance keeps running its scenario, such that an instance left behind by
+// a deferred update is not moved to the latest revision.
+func Reset(ctx context.Context, fschall *fs.Challenge, fsist *fs.Instance, newIdentity bool) error {
+	id := fsist.Identity
+	if newIdentity {
+		id = identity.New()
+	}
+	scn := fsist.RunningScenario(fschall.Scenario)
+	return recreateAs(ctx, scn, scn, id, fschall, fsist)
 }
 
 func up(ctx context.Context, scenario, id string, fschall *fs.Challenge, fsist *fs.Instance) error {
@@ -80,6 +138,7 @@ func up(ctx context.Context, scenario, id string, fschall *fs.Challenge, fsist *
 	// Make sure to extract the state whatever happen, or at least try and store
 	// it in the FS Instance.
 	sr, err := stack.Up(ctx)
+	stack.SaveOutput(ctx, fsist, err)
 	if nerr := stack.Export(ctx, sr, fsist); nerr != nil {
 		if fserr := fsist.Save(); fserr != nil {
 			return err
@@ -92,6 +151,7 @@ func up(ctx context.Context, scenario, id string, fschall *fs.Challenge, fsist *
 		}
 		return err
 	}
+	fsist.Scenario = scenario
 	return nil
 }
 
@@ -34,7 +34,7 @@ func Validate(ctx context.Context, fschall *fsapi.Challenge) error {
 	}
 
 	// Preview stack to ensure it build without error
-	if _, err := stack.pas.Preview(ctx); err != nil {
+	if _, err := stack.Preview(ctx); err != nil {
 		return &errs.ErrScenario{Sub: err}
 	}
 
@@ -0,0 +1,38 @@
+package idempotency
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/ctfer-io/chall-manager/global"
+)
+
+// Records are stored with a lease of the window, thus etcd deletes them once
+// expired.
+func reserveEtcd(ctx context.Context, key string, rec *Record) (*Record, error) {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return nil, err
+	}
+	v, exist, err := global.GetEtcdManager().PutIfAbsent(ctx, etcdKey(key), string(b), global.Conf.IdempotencyWindow)
+	if err != nil || !exist {
+		return nil, err
+	}
+	prev := &Record{}
+	if err := json.Unmarshal([]byte(v), prev); err != nil {
+		return nil, err
+	}
+	return prev, nil
+}
+
+func completeEtcd(ctx context.Context, key string, rec *Record) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return global.GetEtcdManager().PutWithTTL(ctx, etcdKey(key), string(b), global.Conf.IdempotencyWindow)
+}
+
+func releaseEtcd(ctx context.Context, key string) error {
+	return global.GetEtcdManager().Delete(ctx, etcdKey(key))
+}
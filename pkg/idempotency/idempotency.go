@@ -0,0 +1,73 @@
+// Package idempotency makes retried mutating requests return the response of
+// the original one rather than running again, given they carry the same
+// idempotency key.
+// Records are kept for the configured window, in this replica or through etcd
+// if configured such that all replicas share them.
+package idempotency
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/ctfer-io/chall-manager/global"
+	"github.com/ctfer-io/chall-manager/pkg/fs"
+)
+
+// Record of a request identified by an idempotency key.
+type Record struct {
+	// Fingerprint of the request, such that a key reused for another request
+	// is caught.
+	Fingerprint string `json:"fingerprint"`
+
+	// Done is set once the original request completed. Until then, retries are
+	// refused as the response is not known yet.
+	Done bool `json:"done"`
+
+	// Response of the original request, as a marshalled anypb.Any.
+	Response []byte `json:"response,omitempty"`
+}
+
+// Reserve the key for the request with the given fingerprint.
+// If the key is already reserved, its record is returned and the caller must
+// not run the request. Elseway, the caller must either Complete or Release it.
+func Reserve(ctx context.Context, key, fingerprint string) (*Record, error) {
+	rec := &Record{
+		Fingerprint: fingerprint,
+	}
+	if global.Conf.Etcd.Endpoint == "" {
+		return reserveLocal(key, rec), nil
+	}
+	return reserveEtcd(context.WithoutCancel(ctx), key, rec)
+}
+
+// Complete the reservation of the key with the response of the request, for
+// retries to get it.
+func Complete(ctx context.Context, key string, rec *Record) error {
+	rec.Done = true
+	if global.Conf.Etcd.Endpoint == "" {
+		completeLocal(key, rec)
+		return nil
+	}
+	return completeEtcd(context.WithoutCancel(ctx), key, rec)
+}
+
+// Release the reservation of the key, e.g. when the request failed, for a
+// retry to run it again.
+func Release(ctx context.Context, key string) error {
+	if global.Conf.Etcd.Endpoint == "" {
+		releaseLocal(key)
+		return nil
+	}
+	return releaseEtcd(context.WithoutCancel(ctx), key)
+}
+
+// Fingerprint returns the fingerprint of a marshalled request.
+func Fingerprint(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+func etcdKey(key string) string {
+	return "/chall-manager/idempotency/" + fs.Hash(key)
+}
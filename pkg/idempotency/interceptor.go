@@ -0,0 +1,111 @@
+package idempotency
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+
+	"github.com/ctfer-io/chall-manager/global"
+	errs "github.com/ctfer-io/chall-manager/pkg/errors"
+)
+
+// Request is implemented by the requests that can carry an idempotency key.
+type Request interface {
+	proto.Message
+	GetIdempotencyKey() string
+}
+
+var (
+	ErrKeyReused = status.Error(codes.InvalidArgument, "idempotency key already used for a different request")
+	ErrInFlight  = status.Error(codes.Aborted, "a request with the same idempotency key is in progress, retry later")
+)
+
+// UnaryServerInterceptor returns the response of the original request to the
+// requests that carry an already-used idempotency key, rather than handling
+// them again.
+// Only successful responses are kept, such that failed requests can be retried.
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ireq, ok := req.(Request)
+		if !ok || ireq.GetIdempotencyKey() == "" || global.Conf.IdempotencyWindow == 0 {
+			return handler(ctx, req)
+		}
+		logger := global.Log()
+
+		// Keys are scoped per method, as the same key could be generated for
+		// different operations
+		key := info.FullMethod + "/" + ireq.GetIdempotencyKey()
+		b, err := proto.MarshalOptions{Deterministic: true}.Marshal(ireq)
+		if err != nil {
+			err := &errs.ErrInternal{Sub: err}
+			logger.Error(ctx, "marshalling request", zap.Error(err))
+			return nil, errs.ErrInternalNoSub
+		}
+		fingerprint := Fingerprint(b)
+
+		rec, err := Reserve(ctx, key, fingerprint)
+		if err != nil {
+			err := &errs.ErrInternal{Sub: err}
+			logger.Error(ctx, "reserving idempotency key", zap.Error(err))
+			return nil, errs.ErrInternalNoSub
+		}
+		if rec != nil {
+			return replay(ctx, rec, fingerprint)
+		}
+
+		// Failed requests are released such that they can be retried
+		resp, err := handler(ctx, req)
+		if err != nil || resp == nil {
+			if err := Release(context.WithoutCancel(ctx), key); err != nil {
+				logger.Error(ctx, "releasing idempotency key", zap.Error(err))
+			}
+			return resp, err
+		}
+
+		// Keep the response, best effort as the request succeeded.
+		// This is done even if the caller went away, as the operation completed
+		// and must not be run again on retry.
+		rec = &Record{
+			Fingerprint: fingerprint,
+		}
+		if a, err := anypb.New(resp.(proto.Message)); err == nil {
+			rec.Response, err = proto.Marshal(a)
+			if err == nil {
+				err = Complete(context.WithoutCancel(ctx), key, rec)
+			}
+			if err != nil {
+				logger.Error(ctx, "completing idempotency key", zap.Error(err))
+			}
+		}
+		return resp, nil
+	}
+}
+
+func replay(ctx context.Context, rec *Record, fingerprint string) (any, error) {
+	if rec.Fingerprint != fingerprint {
+		return nil, ErrKeyReused
+	}
+	if !rec.Done {
+		return nil, ErrInFlight
+	}
+
+	a := &anypb.Any{}
+	if err := proto.Unmarshal(rec.Response, a); err != nil {
+		err := &errs.ErrInternal{Sub: err}
+		global.Log().Error(ctx, "unmarshalling idempotent response", zap.Error(err))
+		return nil, errs.ErrInternalNoSub
+	}
+	resp, err := a.UnmarshalNew()
+	if err != nil {
+		err := &errs.ErrInternal{Sub: err}
+		global.Log().Error(ctx, "unmarshalling idempotent response", zap.Error(err))
+		return nil, errs.ErrInternalNoSub
+	}
+	global.Log().Info(ctx, "replaying idempotent response")
+	return resp, nil
+}
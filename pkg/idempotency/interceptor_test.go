@@ -0,0 +1,80 @@
+package idempotency_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/ctfer-io/chall-manager/api/v1/instance"
+	"github.com/ctfer-io/chall-manager/global"
+	"github.com/ctfer-io/chall-manager/pkg/idempotency"
+)
+
+func Test_U_UnaryServerInterceptor(t *testing.T) {
+	global.Conf.IdempotencyWindow = time.Minute
+
+	interceptor := idempotency.UnaryServerInterceptor()
+	info := &grpc.UnaryServerInfo{
+		FullMethod: instance.InstanceManager_CreateInstance_FullMethodName,
+	}
+	calls := 0
+	fail := false
+	handler := func(_ context.Context, req any) (any, error) {
+		calls++
+		if fail {
+			return nil, errors.New("failed")
+		}
+		return &instance.Instance{
+			ChallengeId:    req.(*instance.CreateInstanceRequest).ChallengeId,
+			SourceId:       req.(*instance.CreateInstanceRequest).SourceId,
+			ConnectionInfo: "nc localhost 1337",
+		}, nil
+	}
+	req := &instance.CreateInstanceRequest{
+		ChallengeId:    "1",
+		SourceId:       "1",
+		IdempotencyKey: "create-1-1",
+	}
+
+	// The first request is handled
+	resp, err := interceptor(t.Context(), req, info, handler)
+	require.NoError(t, err)
+	assert.Equal(t, 1, calls)
+
+	// A retry returns the original response without being handled
+	retry, err := interceptor(t.Context(), proto.Clone(req), info, handler)
+	require.NoError(t, err)
+	assert.Equal(t, 1, calls)
+	assert.True(t, proto.Equal(resp.(proto.Message), retry.(proto.Message)))
+
+	// The key can't be reused for a different request
+	other := proto.Clone(req).(*instance.CreateInstanceRequest)
+	other.SourceId = "2"
+	_, err = interceptor(t.Context(), other, info, handler)
+	assert.ErrorIs(t, err, idempotency.ErrKeyReused)
+	assert.Equal(t, 1, calls)
+
+	// Failures are not kept, thus retried
+	fail = true
+	req.IdempotencyKey = "create-1-1-bis"
+	_, err = interceptor(t.Context(), req, info, handler)
+	assert.Error(t, err)
+	fail = false
+	_, err = interceptor(t.Context(), req, info, handler)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, calls)
+
+	// Requests without key are always handled
+	req.IdempotencyKey = ""
+	_, err = interceptor(t.Context(), req, info, handler)
+	assert.NoError(t, err)
+	_, err = interceptor(t.Context(), req, info, handler)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, calls)
+}
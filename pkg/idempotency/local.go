@@ -0,0 +1,57 @@
+package idempotency
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ctfer-io/chall-manager/global"
+)
+
+type localRecord struct {
+	rec     *Record
+	expires time.Time
+}
+
+var (
+	localRecs   = map[string]*localRecord{}
+	localRecsMx sync.Mutex
+)
+
+func reserveLocal(key string, rec *Record) *Record {
+	localRecsMx.Lock()
+	defer localRecsMx.Unlock()
+
+	// Expired records are dropped lazily
+	now := time.Now()
+	for k, lrec := range localRecs {
+		if now.After(lrec.expires) {
+			delete(localRecs, k)
+		}
+	}
+
+	if lrec, ok := localRecs[key]; ok {
+		return lrec.rec
+	}
+	localRecs[key] = &localRecord{
+		rec:     rec,
+		expires: now.Add(global.Conf.IdempotencyWindow),
+	}
+	return nil
+}
+
+func completeLocal(key string, rec *Record) {
+	localRecsMx.Lock()
+	defer localRecsMx.Unlock()
+
+	localRecs[key] = &localRecord{
+		rec:     rec,
+		expires: time.Now().Add(global.Conf.IdempotencyWindow),
+	}
+}
+
+func releaseLocal(key string) {
+	localRecsMx.Lock()
+	defer localRecsMx.Unlock()
+
+	delete(localRecs, key)
+}
@@ -0,0 +1,203 @@
+/*
+Package labels implements the Kubernetes-style label selectors used to match
+challenges by their labels, e.g. `category=web,difficulty!=easy`.
+
+It supports the equality-based (`=`, `==`, `!=`) and set-based (`in`, `notin`,
+existence `key` and non-existence `!key`) requirements, all of which must be
+satisfied for a selector to match.
+*/
+package labels
+
+import (
+	"slices"
+	"strings"
+
+	errs "github.com/ctfer-io/chall-manager/pkg/errors"
+)
+
+// Operator of a Requirement.
+type Operator string
+
+const (
+	Equals       Operator = "="
+	NotEquals    Operator = "!="
+	In           Operator = "in"
+	NotIn        Operator = "notin"
+	Exists       Operator = "exists"
+	DoesNotExist Operator = "!"
+)
+
+// Requirement on a single label.
+type Requirement struct {
+	Key      string
+	Operator Operator
+	Values   []string
+}
+
+// Selector is a set of requirements, all of which must be satisfied.
+// The zero value matches everything.
+type Selector []Requirement
+
+// Parse a label selector.
+// An empty selector matches everything.
+func Parse(selector string) (Selector, error) {
+	sel := Selector{}
+	for _, raw := range split(selector) {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			if strings.TrimSpace(selector) == "" {
+				continue
+			}
+			return nil, &errs.ErrInvalidSelector{Selector: selector, Reason: "empty requirement"}
+		}
+		req, reason := parseRequirement(raw)
+		if reason != "" {
+			return nil, &errs.ErrInvalidSelector{Selector: selector, Reason: reason}
+		}
+		sel = append(sel, req)
+	}
+	return sel, nil
+}
+
+// Matches returns whether the labels satisfy all the requirements.
+func (sel Selector) Matches(labels map[string]string) bool {
+	for _, req := range sel {
+		if !req.Matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+// Empty returns whether the selector matches everything.
+func (sel Selector) Empty() bool {
+	return len(sel) == 0
+}
+
+// Matches returns whether the labels satisfy the requirement.
+func (req Requirement) Matches(labels map[string]string) bool {
+	v, ok := labels[req.Key]
+	switch req.Operator {
+	case Equals, In:
+		return ok && slices.Contains(req.Values, v)
+	case NotEquals, NotIn:
+		return !ok || !slices.Contains(req.Values, v)
+	case Exists:
+		return ok
+	case DoesNotExist:
+		return !ok
+	}
+	return false
+}
+
+// Validate checks the labels could be matched by a selector, i.e. their keys
+// and values only contain alphanumeric characters, '-', '_', '.' or '/'.
+func Validate(labels map[string]string) error {
+	for k, v := range labels {
+		if k == "" {
+			return &errs.ErrInvalidLabel{Key: k, Value: v, Reason: "empty key"}
+		}
+		if !valid(k) {
+			return &errs.ErrInvalidLabel{Key: k, Value: v, Reason: "invalid key"}
+		}
+		if !valid(v) {
+			return &errs.ErrInvalidLabel{Key: k, Value: v, Reason: "invalid value"}
+		}
+	}
+	return nil
+}
+
+// split the selector on the commas that separate requirements, i.e. not the
+// ones in the set of values of an "in" or "notin" requirement.
+func split(selector string) []string {
+	out := []string{}
+	depth, start := 0, 0
+	for i, r := range selector {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				out = append(out, selector[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(out, selector[start:])
+}
+
+func parseRequirement(raw string) (Requirement, string) {
+	// Non-existence: "!key"
+	if key, ok := strings.CutPrefix(raw, "!"); ok && !strings.Contains(key, "=") {
+		key = strings.TrimSpace(key)
+		if key == "" || !valid(key) {
+			return Requirement{}, "invalid key in " + raw
+		}
+		return Requirement{Key: key, Operator: DoesNotExist}, ""
+	}
+
+	// Set-based: "key in (a,b)" or "key notin (a,b)"
+	if left, right, ok := strings.Cut(raw, "("); ok {
+		fields := strings.Fields(left)
+		if len(fields) != 2 || !valid(fields[0]) {
+			return Requirement{}, "invalid set requirement " + raw
+		}
+		op := Operator(fields[1])
+		if op != In && op != NotIn {
+			return Requirement{}, "unknown operator " + fields[1]
+		}
+		right, ok := strings.CutSuffix(strings.TrimSpace(right), ")")
+		if !ok || strings.ContainsAny(right, "()") {
+			return Requirement{}, "unclosed set in " + raw
+		}
+		values := strings.Split(right, ",")
+		for i, v := range values {
+			values[i] = strings.TrimSpace(v)
+			if !valid(values[i]) {
+				return Requirement{}, "invalid value in " + raw
+			}
+		}
+		return Requirement{Key: fields[0], Operator: op, Values: values}, ""
+	}
+
+	// Equality-based: "key=value", "key==value" or "key!=value"
+	op := Equals
+	key, value, ok := strings.Cut(raw, "!=")
+	if ok {
+		op = NotEquals
+	} else if key, value, ok = strings.Cut(raw, "=="); !ok {
+		key, value, ok = strings.Cut(raw, "=")
+	}
+	if ok {
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if key == "" || !valid(key) {
+			return Requirement{}, "invalid key in " + raw
+		}
+		if !valid(value) {
+			return Requirement{}, "invalid value in " + raw
+		}
+		return Requirement{Key: key, Operator: op, Values: []string{value}}, ""
+	}
+
+	// Existence: "key"
+	if !valid(raw) {
+		return Requirement{}, "invalid key " + raw
+	}
+	return Requirement{Key: raw, Operator: Exists}, ""
+}
+
+// valid returns whether a key or value only contains the characters a selector
+// can express. Values can be empty.
+func valid(s string) bool {
+	for _, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '-', r == '_', r == '.', r == '/':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,105 @@
+package labels_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	errs "github.com/ctfer-io/chall-manager/pkg/errors"
+	"github.com/ctfer-io/chall-manager/pkg/labels"
+)
+
+func Test_U_Selector(t *testing.T) {
+	t.Parallel()
+
+	lbls := map[string]string{
+		"category":   "web",
+		"difficulty": "medium",
+		"event":      "ctf-2026",
+	}
+
+	var tests = map[string]struct {
+		Selector  string
+		ExpectErr bool
+		Matches   bool
+	}{
+		"empty": {
+			Selector: "",
+			Matches:  true,
+		},
+		"equals": {
+			Selector: "category=web",
+			Matches:  true,
+		},
+		"double-equals": {
+			Selector: "category==pwn",
+			Matches:  false,
+		},
+		"equals-and-not-equals": {
+			Selector: "category=web, difficulty!=easy",
+			Matches:  true,
+		},
+		"not-equals-missing": {
+			Selector: "author!=pandatix",
+			Matches:  true,
+		},
+		"in": {
+			Selector: "difficulty in (easy, medium),event=ctf-2026",
+			Matches:  true,
+		},
+		"notin": {
+			Selector: "difficulty notin (medium,hard)",
+			Matches:  false,
+		},
+		"exists": {
+			Selector: "event",
+			Matches:  true,
+		},
+		"does-not-exist": {
+			Selector: "!author",
+			Matches:  true,
+		},
+		"trailing-comma": {
+			Selector:  "category=web,",
+			ExpectErr: true,
+		},
+		"unknown-operator": {
+			Selector:  "difficulty within (easy)",
+			ExpectErr: true,
+		},
+		"unclosed-set": {
+			Selector:  "difficulty in (easy",
+			ExpectErr: true,
+		},
+		"invalid-key": {
+			Selector:  "cate gory=web",
+			ExpectErr: true,
+		},
+	}
+
+	for testname, tt := range tests {
+		t.Run(testname, func(t *testing.T) {
+			t.Parallel()
+			assert := assert.New(t)
+
+			sel, err := labels.Parse(tt.Selector)
+			if tt.ExpectErr {
+				assert.IsType(&errs.ErrInvalidSelector{}, err)
+				return
+			}
+			if !assert.NoError(err) {
+				return
+			}
+			assert.Equal(tt.Matches, sel.Matches(lbls))
+		})
+	}
+}
+
+func Test_U_Validate(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	assert.NoError(labels.Validate(map[string]string{"category": "web", "event": ""}))
+	assert.IsType(&errs.ErrInvalidLabel{}, labels.Validate(map[string]string{"": "web"}))
+	assert.IsType(&errs.ErrInvalidLabel{}, labels.Validate(map[string]string{"category": "web,pwn"}))
+}
@@ -7,8 +7,49 @@ import (
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 )
 
+// CallerMetadataKey is the gRPC metadata key the client interceptors use to
+// forward the caller function to the server.
+const CallerMetadataKey = "x-caller-function"
+
+// Caller describes who issued a request, e.g. for audit purposes.
+type Caller struct {
+	Function  string `json:"function,omitempty"`
+	Address   string `json:"address,omitempty"`
+	UserAgent string `json:"user_agent,omitempty"`
+}
+
+// CallerFromContext extracts the caller of an incoming request.
+// Requests that went through the gateway are attributed to the forwarded
+// address and user agent, rather than the gateway ones.
+func CallerFromContext(ctx context.Context) Caller {
+	c := Caller{}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		c.Address = p.Addr.String()
+	}
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return c
+	}
+	first := func(keys ...string) string {
+		for _, key := range keys {
+			if vals := md.Get(key); len(vals) != 0 {
+				return vals[0]
+			}
+		}
+		return ""
+	}
+	c.Function = first(CallerMetadataKey)
+	if addr := first("x-forwarded-for"); addr != "" {
+		c.Address = addr
+	}
+	c.UserAgent = first("grpcgateway-user-agent", "user-agent")
+	return c
+}
+
 func UnaryClientInterceptorWithCaller(tracer trace.Tracer) grpc.UnaryClientInterceptor {
 	return func(
 		ctx context.Context,
@@ -31,6 +72,7 @@ func UnaryClientInterceptorWithCaller(tracer trace.Tracer) grpc.UnaryClientInter
 		ctx, span := tracer.Start(ctx, method)
 		defer span.End()
 		span.SetAttributes(attribute.String("caller.function", caller))
+		ctx = metadata.AppendToOutgoingContext(ctx, CallerMetadataKey, caller)
 
 		return invoker(ctx, method, req, reply, cc, opts...)
 	}
@@ -57,6 +99,7 @@ func StreamClientInterceptorWithCaller(tracer trace.Tracer) grpc.StreamClientInt
 		// Start span for the stream
 		ctx, span := tracer.Start(ctx, method)
 		span.SetAttributes(attribute.String("caller.function", caller))
+		ctx = metadata.AppendToOutgoingContext(ctx, CallerMetadataKey, caller)
 
 		// Call the actual streamer to get the client stream
 		clientStream, err := streamer(ctx, desc, cc, method, opts...)
@@ -140,6 +140,75 @@ func (m *Manager) Put(ctx context.Context, k, v string) (*clientv3.PutResponse,
 	return cli.Put(ctx, k, v)
 }
 
+// PutIfAbsent puts the key with a lease of the given TTL, only if it does not
+// exist yet. If it does, its current value is returned instead.
+func (m *Manager) PutIfAbsent(ctx context.Context, k, v string, ttl time.Duration) (string, bool, error) {
+	cli, err := m.getClient(context.WithoutCancel(ctx)) // avoid cancelation as we'll reuse the client if recreated
+	if err != nil {
+		return "", false, err
+	}
+	lease, err := cli.Grant(ctx, ttlSeconds(ttl))
+	if err != nil {
+		return "", false, err
+	}
+	res, err := cli.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(k), "=", 0)).
+		Then(clientv3.OpPut(k, v, clientv3.WithLease(lease.ID))).
+		Else(clientv3.OpGet(k)).
+		Commit()
+	if err != nil {
+		return "", false, err
+	}
+	if res.Succeeded {
+		return "", false, nil
+	}
+	// The lease is useless, let etcd expire it if revoking fails
+	_, _ = cli.Revoke(ctx, lease.ID)
+	kvs := res.Responses[0].GetResponseRange().Kvs
+	if len(kvs) == 0 {
+		// Expired in between, consider it absent next time
+		return "", false, nil
+	}
+	return string(kvs[0].Value), true, nil
+}
+
+// PutWithTTL puts the key with a lease of the given TTL, such that etcd deletes
+// it once expired.
+func (m *Manager) PutWithTTL(ctx context.Context, k, v string, ttl time.Duration) error {
+	cli, err := m.getClient(context.WithoutCancel(ctx)) // avoid cancelation as we'll reuse the client if recreated
+	if err != nil {
+		return err
+	}
+	lease, err := cli.Grant(ctx, ttlSeconds(ttl))
+	if err != nil {
+		return err
+	}
+	_, err = cli.Put(ctx, k, v, clientv3.WithLease(lease.ID))
+	return err
+}
+
+func (m *Manager) Delete(ctx context.Context, k string) error {
+	cli, err := m.getClient(context.WithoutCancel(ctx)) // avoid cancelation as we'll reuse the client if recreated
+	if err != nil {
+		return err
+	}
+	_, err = cli.Delete(ctx, k)
+	return err
+}
+
+// ttlSeconds rounds the TTL up to the second, as etcd leases are.
+func ttlSeconds(ttl time.Duration) int64 {
+	return int64((ttl + time.Second - 1) / time.Second)
+}
+
+func (m *Manager) Watch(ctx context.Context, k string, opts ...clientv3.OpOption) (clientv3.WatchChan, error) {
+	cli, err := m.getClient(context.WithoutCancel(ctx)) // avoid cancelation as we'll reuse the client if recreated
+	if err != nil {
+		return nil, err
+	}
+	return cli.Watch(ctx, k, opts...), nil
+}
+
 func (m *Manager) Healthcheck(ctx context.Context) error {
 	_, err := m.getClient(context.WithoutCancel(ctx)) // avoid cancelation as we'll reuse the client if recreated
 	return err
@@ -216,3 +216,21 @@ func compile(dir string) error {
 	}
 	return nil
 }
+
+// Digest resolves a reference to its pinned form, i.e. `<name>@sha256:<digest>`,
+// such that it can be loaded identically later on, even if its tag moved.
+func (mg *Manager) Digest(
+	ctx context.Context,
+	ref string,
+) (string, error) {
+	l, _ := mg.locks.LoadOrStore(ref, &sync.Mutex{})
+	lock := l.(*sync.Mutex)
+	lock.Lock()
+	defer lock.Unlock()
+
+	name, dig, err := mg.resolve(ctx, ref, mg.insecure, mg.username, mg.password)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s@%s", name, dig), nil
+}
@@ -53,6 +53,9 @@ func Run(f Factory) {
 			ctx.Export("flag", resp.Flag)
 		}
 		ctx.Export("flags", resp.Flags)
+		if len(resp.Outputs) != 0 {
+			ctx.Export("outputs", exportOutputs(resp.Outputs))
+		}
 
 		return nil
 	})
@@ -73,6 +76,50 @@ type Response struct {
 	Flag pulumi.StringOutput
 
 	Flags pulumi.StringArrayOutput
+
+	// Outputs are typed values returned along the connection information,
+	// e.g. credentials, endpoints or SSH keys.
+	// Use [Secret] for values that must be masked in logs and only returned to
+	// the source that owns the instance.
+	Outputs map[string]Output
+}
+
+// Output is a typed value returned by the factory.
+type Output struct {
+	Value  pulumi.Input
+	Secret bool
+}
+
+// Public creates an output that is returned to whoever can see the instance.
+func Public(v pulumi.Input) Output {
+	return Output{
+		Value: v,
+	}
+}
+
+// Secret creates an output that is masked in logs and only returned to the
+// source that owns the instance.
+func Secret(v pulumi.Input) Output {
+	return Output{
+		Value:  v,
+		Secret: true,
+	}
+}
+
+func exportOutputs(outs map[string]Output) pulumi.Map {
+	m := pulumi.Map{}
+	for k, out := range outs {
+		v := out.Value
+		if out.Secret {
+			// Also encrypts it in the stack state
+			v = pulumi.ToSecret(v)
+		}
+		m[k] = pulumi.Map{
+			"value":  v,
+			"secret": pulumi.Bool(out.Secret),
+		}
+	}
+	return m
 }
 
 // Configuration is the struct that contains the flattened configuration
@@ -21,6 +21,7 @@ import (
 	"github.com/ctfer-io/chall-manager/api/v1/challenge"
 	"github.com/ctfer-io/chall-manager/api/v1/instance"
 	"github.com/ctfer-io/chall-manager/global"
+	"github.com/ctfer-io/chall-manager/pkg/idempotency"
 )
 
 // Server is a helper to manager an API Server.
@@ -119,7 +120,10 @@ func (s *Server) newGRPCServer() *grpc.Server {
 	// Create the gRPC server
 	opts := []grpc.ServerOption{
 		grpc.StatsHandler(otelgrpc.NewServerHandler()),
-		grpc.UnaryInterceptor(recovery.UnaryServerInterceptor()),
+		grpc.ChainUnaryInterceptor(
+			recovery.UnaryServerInterceptor(),
+			idempotency.UnaryServerInterceptor(),
+		),
 		grpc.StreamInterceptor(recovery.StreamServerInterceptor()),
 	}
 	grpcServer := grpc.NewServer(opts...)